@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+type Attachment struct {
+	Id       string
+	Filename string
+	MimeType string
+	Size     int64
+}
+
+// findAttachments collects every part with a filename that Gmail stores
+// separately from the message.
+func findAttachments(part *gmail.MessagePart) []Attachment {
+	if part == nil {
+		return nil
+	}
+	attachments := []Attachment{}
+	if part.Filename != "" && part.Body != nil && part.Body.AttachmentId != "" {
+		attachments = append(attachments, Attachment{Id: part.Body.AttachmentId, Filename: part.Filename, MimeType: part.MimeType, Size: part.Body.Size})
+	}
+	for _, p := range part.Parts {
+		attachments = append(attachments, findAttachments(p)...)
+	}
+	return attachments
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func printAttachments(messages []Message) {
+	found := false
+	for _, m := range messages {
+		if len(m.Attachments) == 0 {
+			continue
+		}
+		found = true
+		fmt.Println("")
+		fmt.Println(bold("Subject: " + strings.TrimSpace(m.Subject)))
+		for _, a := range m.Attachments {
+			fmt.Printf("  %s (%s)\n", a.Filename, formatSize(a.Size))
+		}
+	}
+	if !found {
+		fmt.Println("No attachments found.")
+	}
+}
+
+// createUnique creates dir/name, or the first free "name (n).ext" when that
+// file already exists.
+func createUnique(dir string, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 0; ; n++ {
+		candidate := name
+		if n > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		}
+		f, err := os.OpenFile(dir+"/"+candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, os.ErrExist) {
+			return f, err
+		}
+	}
+}
+
+// downloadAttachments saves the attachments of every message into dir,
+// keeping their original filenames. Each download is limited to timeout.
+func downloadAttachments(ctx context.Context, client *http.Client, messages []Message, dir string, timeout time.Duration) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("unable to create %s: %w", dir, err)
+	}
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, m := range messages {
+		for _, a := range m.Attachments {
+			getCtx, cancel := requestContext(ctx, timeout)
+			body, err := withRetry(getCtx, srv.Users.Messages.Attachments.Get(mailbox, m.Id, a.Id).Context(getCtx).Do)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("unable to retrieve attachment %q: %w", a.Filename, err)
+			}
+			data, err := decodeBase64URL(body.Data)
+			if err != nil {
+				return fmt.Errorf("unable to decode attachment %q: %w", a.Filename, err)
+			}
+
+			// Filenames come from the sender, so never let them leave dir.
+			name := filepath.Base(a.Filename)
+			if name == "." || name == ".." || name == string(filepath.Separator) {
+				name = "attachment"
+			}
+			f, err := createUnique(dir, name)
+			if err != nil {
+				return fmt.Errorf("unable to save attachment %q: %w", a.Filename, err)
+			}
+			_, err = f.Write(data)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("unable to save attachment %q: %w", a.Filename, err)
+			}
+			fmt.Println("Saved:", f.Name())
+			count++
+		}
+	}
+	fmt.Printf("Saved %d attachments to %s\n", count, dir)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// Auth failures that call for different advice. They are wrapped, so check
+// them with errors.Is.
+var (
+	ErrNoCredentials = errors.New("no OAuth client credentials")
+	ErrTokenExpired  = errors.New("the saved token has expired or was revoked")
+	ErrAuthDenied    = errors.New("access was denied")
+	// The saved token is encrypted with a passphrase that wasn't given, or
+	// another one.
+	ErrPassphraseRequired = errors.New("the saved token is encrypted")
+	ErrWrongPassphrase    = errors.New("wrong passphrase for the saved token")
+)
+
+// oauthError wraps errors from Google's token endpoint in the auth error
+// for their OAuth error code.
+func oauthError(err error) error {
+	var retrieve *oauth2.RetrieveError
+	if !errors.As(err, &retrieve) {
+		return err
+	}
+	switch retrieve.ErrorCode {
+	case "invalid_grant":
+		return fmt.Errorf("%w: %w", ErrTokenExpired, err)
+	case "access_denied":
+		return fmt.Errorf("%w: %w", ErrAuthDenied, err)
+	}
+	return err
+}
+
+// expiryTokenSource marks refresh failures with the auth errors.
+type expiryTokenSource struct {
+	source oauth2.TokenSource
+}
+
+func (s expiryTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.source.Token()
+	if err != nil {
+		return nil, oauthError(err)
+	}
+	return tok, nil
+}
+
+// authAdvice tells how to get past an auth error, or returns "" when there
+// is nothing specific to do.
+func authAdvice(err error) string {
+	switch {
+	case errors.Is(err, ErrNoCredentials):
+		return "Create an OAuth client at https://console.cloud.google.com/apis/credentials and give butler its credentials.json."
+	case errors.Is(err, ErrTokenExpired) && os.Getenv("BUTLER_TOKEN_B64") != "":
+		return "Log in again on a machine with a browser and update BUTLER_TOKEN_B64 with the new token.json."
+	case errors.Is(err, ErrTokenExpired):
+		return "Run butler -logout and try again to log in anew."
+	case errors.Is(err, ErrAuthDenied):
+		return "butler needs the access it asks for, run the command again and allow it."
+	case errors.Is(err, ErrPassphraseRequired), errors.Is(err, ErrWrongPassphrase):
+		return "Give the passphrase the token was saved with in BUTLER_PASSPHRASE or with -passphrase, or run butler -logout to log in anew."
+	}
+	return ""
+}
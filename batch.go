@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+const (
+	gmailBatchURL = "https://gmail.googleapis.com/batch/gmail/v1"
+	batchSize     = 100
+)
+
+// batchGetMessages gets up to batchSize messages in a single request to
+// Gmail's batch endpoint. Messages whose part of the response failed are
+// missing from the result, an error means the whole batch failed.
+func batchGetMessages(ctx context.Context, client *http.Client, user string, ids []string, headers []string) (map[string]*gmail.Message, error) {
+	params := url.Values{"format": {messageFormat(headers)}}
+	if len(headers) > 0 {
+		params["metadataHeaders"] = headers
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for i, id := range ids {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", "<item"+strconv.Itoa(i)+">")
+		part, err := w.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(part, "GET /gmail/v1/users/%s/messages/%s?%s\r\n\r\n", url.PathEscape(user), url.PathEscape(id), params.Encode())
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gmailBatchURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+w.Boundary())
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("batch request failed: %s", resp.Status)
+	}
+
+	mediaType, mediaParams, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("unexpected batch response type %q", resp.Header.Get("Content-Type"))
+	}
+
+	messages := map[string]*gmail.Message{}
+	reader := multipart.NewReader(resp.Body, mediaParams["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return messages, nil
+			}
+			return nil, fmt.Errorf("unable to read batch response: %w", err)
+		}
+		partResp, err := http.ReadResponse(bufio.NewReader(part), req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read batch response: %w", err)
+		}
+		if partResp.StatusCode != http.StatusOK {
+			debugf("Batch item %s failed: %s", part.Header.Get("Content-ID"), partResp.Status)
+			partResp.Body.Close()
+			continue
+		}
+		msg := &gmail.Message{}
+		err = json.NewDecoder(partResp.Body).Decode(msg)
+		partResp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode batch response: %w", err)
+		}
+		messages[msg.Id] = msg
+	}
+}
+
+// batchFetchMessages gets the listed messages through the batch endpoint,
+// falling back to fetching one by one for batches that fail and for
+// messages missing from a batch response.
+func batchFetchMessages(ctx context.Context, client *http.Client, srv *gmail.Service, user string, listed []*gmail.Message, workers int, headers []string) []Message {
+	progress := newProgress("Fetching messages", len(listed))
+	defer progress.finish()
+	fetched := map[string]Message{}
+	remaining := []*gmail.Message{}
+	for start := 0; start < len(listed); start += batchSize {
+		chunk := listed[start:min(start+batchSize, len(listed))]
+		ids := []string{}
+		for _, m := range chunk {
+			ids = append(ids, m.Id)
+		}
+		// Quotas count every message of a batch.
+		var got map[string]*gmail.Message
+		err := waitFetches(ctx, len(ids))
+		if err == nil {
+			got, err = batchGetMessages(ctx, client, user, ids, headers)
+		}
+		if err != nil {
+			debugf("Fetching messages one by one: %v", err)
+		}
+		for _, m := range chunk {
+			if msg, ok := got[m.Id]; ok {
+				fetched[m.Id] = toMessage(msg)
+			} else {
+				remaining = append(remaining, m)
+			}
+		}
+		progress.add(len(got))
+	}
+	for _, m := range fetchMessages(ctx, srv, user, remaining, workers, headers, progress) {
+		fetched[m.Id] = m
+	}
+
+	messages := []Message{}
+	for _, m := range listed {
+		if msg, ok := fetched[m.Id]; ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
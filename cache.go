@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const messageCacheTTL = 24 * time.Hour
+
+// labelCacheTTL is shorter than for messages, as labels can be renamed.
+const labelCacheTTL = 6 * time.Hour
+
+// cacheEntry records whether the message was fetched in full, as entries
+// from -snippet runs have no body. Labels are left out of the message as they
+// change whenever a message is read, archived or starred.
+type cacheEntry struct {
+	Mailbox  string
+	Message  Message
+	Full     bool
+	CachedAt time.Time
+}
+
+// messageCache keeps the headers and bodies of parsed messages on disk keyed
+// by mailbox and message ID so repeated runs only fetch messages they haven't
+// seen recently.
+type messageCache struct {
+	path    string
+	entries map[string]cacheEntry
+}
+
+func getProfileCacheDir() (string, error) {
+	cacheDir, err := getCacheDir()
+	if err != nil {
+		return "", err
+	}
+	profileCacheDir := cacheDir + "/" + profile
+	if err := os.MkdirAll(profileCacheDir, 0700); err != nil {
+		return "", fmt.Errorf("unable to create cache directory: %w", err)
+	}
+	return profileCacheDir, nil
+}
+
+func getMessageCachePath() (string, error) {
+	profileCacheDir, err := getProfileCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return profileCacheDir + "/messages.json", nil
+}
+
+// loadMessageCache reads the cache of the active profile. When its directory
+// can't be created the cache only lives in memory for this run.
+func loadMessageCache() *messageCache {
+	cache := &messageCache{entries: map[string]cacheEntry{}}
+	path, err := getMessageCachePath()
+	if err != nil {
+		log.Printf("Not caching messages: %v", err)
+		return cache
+	}
+	cache.path = path
+	data, err := os.ReadFile(cache.path)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		log.Printf("Ignoring unreadable message cache %s: %v", cache.path, err)
+		cache.entries = map[string]cacheEntry{}
+	}
+	return cache
+}
+
+func messageCacheKey(id string) string {
+	return mailbox + "/" + id
+}
+
+// get returns the cached message of the mailbox in use, without labels.
+func (c *messageCache) get(id string, full bool) (Message, bool) {
+	entry, ok := c.entries[messageCacheKey(id)]
+	if !ok || time.Since(entry.CachedAt) > messageCacheTTL || (full && !entry.Full) {
+		return Message{}, false
+	}
+	return entry.Message, true
+}
+
+func (c *messageCache) put(m Message, full bool) {
+	m.Labels = nil
+	c.entries[messageCacheKey(m.Id)] = cacheEntry{Mailbox: mailbox, Message: m, Full: full, CachedAt: time.Now()}
+}
+
+// save writes the cache back to disk, dropping expired entries.
+func (c *messageCache) save() {
+	if c.path == "" {
+		return
+	}
+	for id, entry := range c.entries {
+		if time.Since(entry.CachedAt) > messageCacheTTL {
+			delete(c.entries, id)
+		}
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		log.Printf("Unable to encode message cache: %v", err)
+		return
+	}
+	if err := os.WriteFile(c.path, data, 0600); err != nil {
+		log.Printf("Unable to write message cache: %v", err)
+	}
+}
+
+func clearMessageCache() error {
+	path, err := getMessageCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to clear message cache: %w", err)
+	}
+	labelPath, err := getLabelCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(labelPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to clear label cache: %w", err)
+	}
+	fmt.Println("Cleared message cache", path)
+	return nil
+}
+
+// refreshLabels skips the label cache for a run, set with -refresh-labels.
+var refreshLabels bool
+
+// labelCache is the label list of a mailbox, so that resolving -l names
+// doesn't need a request every run.
+type labelCache struct {
+	Mailbox  string
+	Labels   []Label
+	CachedAt time.Time
+}
+
+func getLabelCachePath() (string, error) {
+	profileCacheDir, err := getProfileCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return profileCacheDir + "/labels.json", nil
+}
+
+// loadCachedLabels returns the cached labels of the mailbox in use unless
+// they are stale.
+func loadCachedLabels() ([]Label, bool) {
+	path, err := getLabelCachePath()
+	if err != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var cache labelCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		debugf("Ignoring unreadable label cache %s: %v", path, err)
+		return nil, false
+	}
+	if cache.Mailbox != mailbox || time.Since(cache.CachedAt) > labelCacheTTL {
+		return nil, false
+	}
+	return cache.Labels, true
+}
+
+func saveCachedLabels(labels []Label) {
+	path, err := getLabelCachePath()
+	if err != nil {
+		debugf("Not caching labels: %v", err)
+		return
+	}
+	data, err := json.Marshal(labelCache{Mailbox: mailbox, Labels: labels, CachedAt: time.Now()})
+	if err != nil {
+		log.Printf("Unable to encode label cache: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		log.Printf("Unable to write label cache: %v", err)
+	}
+}
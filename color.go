@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+var useColor = true
+
+// initColor turns escape sequences off when asked to with -no-color or
+// NO_COLOR, or when stdout is not a terminal.
+func initColor(noColor bool) {
+	useColor = colorEnabled(noColor, os.Getenv("NO_COLOR"), term.IsTerminal(int(os.Stdout.Fd())))
+}
+
+func colorEnabled(noColor bool, noColorEnv string, terminal bool) bool {
+	return !noColor && noColorEnv == "" && terminal
+}
+
+func bold(s string) string {
+	if !useColor {
+		return s
+	}
+	return "\033[1m" + s + "\033[0m"
+}
+
+func dim(s string) string {
+	if !useColor {
+		return s
+	}
+	return "\033[2m" + s + "\033[0m"
+}
+
+func red(s string) string {
+	if !useColor {
+		return s
+	}
+	return "\033[31m" + s + "\033[0m"
+}
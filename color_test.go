@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestColorEnabled(t *testing.T) {
+	tests := []struct {
+		name       string
+		noColor    bool
+		noColorEnv string
+		terminal   bool
+		want       bool
+	}{
+		{"terminal", false, "", true, true},
+		{"piped", false, "", false, false},
+		{"-no-color", true, "", true, false},
+		{"NO_COLOR", false, "1", true, false},
+		{"NO_COLOR set to anything", false, "false", true, false},
+		{"piped with -no-color", true, "", false, false},
+	}
+	for _, test := range tests {
+		if got := colorEnabled(test.noColor, test.noColorEnv, test.terminal); got != test.want {
+			t.Errorf("%s: colorEnabled = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestColorOutput(t *testing.T) {
+	defer func(color bool) { useColor = color }(useColor)
+
+	useColor = true
+	if got := bold("Today"); got != "\033[1mToday\033[0m" {
+		t.Errorf("bold with color = %q", got)
+	}
+	if got := red("soon"); got != "\033[31msoon\033[0m" {
+		t.Errorf("red with color = %q", got)
+	}
+
+	useColor = false
+	for _, got := range []string{bold("Today"), dim("Today"), red("Today")} {
+		if got != "Today" {
+			t.Errorf("without color got %q, want plain text", got)
+		}
+	}
+}
+
+func TestWrittenColors(t *testing.T) {
+	defer func(color bool) { useColor = color }(useColor)
+	messages := []Message{{Subject: "Report", Sender: "jane@example.com"}}
+	events := []Event{{Summary: "Standup", StartTime: time.Now(), EndDateTime: time.Now().Add(time.Hour).Format(time.RFC3339)}}
+
+	for _, color := range []bool{true, false} {
+		useColor = color
+		var b strings.Builder
+		writeMessages(&b, messages, PrintOptions{})
+		writeEvents(&b, events)
+		if got := strings.Contains(b.String(), "\033["); got != color {
+			t.Errorf("with color %v, escape codes in output = %v:\n%q", color, got, b.String())
+		}
+	}
+}
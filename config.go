@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Config holds defaults for command line flags, read from config.json in the
+// config directory. Flags given on the command line take precedence and
+// unknown keys are ignored.
+type Config struct {
+	Labels   string  `json:"labels"`
+	Messages int64   `json:"messages"`
+	Calendar string  `json:"calendar"`
+	NoColor  bool    `json:"no_color"`
+	Workers  int     `json:"workers"`
+	Rate     float64 `json:"rate"`
+}
+
+func getConfigPath() (string, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return configDir + "/config.json", nil
+}
+
+// defaultConfig is what applies without a config file, and the defaults
+// -help shows.
+func defaultConfig() Config {
+	return Config{
+		Labels:   "UNREAD",
+		Messages: 100,
+		Calendar: "primary",
+		Workers:  8,
+	}
+}
+
+func loadConfig() (Config, error) {
+	config := defaultConfig()
+	path, err := getConfigPath()
+	if err != nil {
+		return config, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("unable to read config file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, usageErrorf("unable to parse config file %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// givenFlags returns the names of the flags set on the command line, which
+// the config file doesn't override.
+func givenFlags() map[string]bool {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return given
+}
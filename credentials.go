@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// oauthClientJSON is an OAuth client as downloaded from the Google Cloud
+// console, nested under "installed" for Desktop apps or "web".
+type oauthClientJSON struct {
+	ClientId     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	AuthURI      string   `json:"auth_uri"`
+	TokenURI     string   `json:"token_uri"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// credentialsJSON covers the credential files people end up with: OAuth
+// clients of either kind, service account keys, which have a "type", and the
+// inner object of an OAuth client copied without its wrapper.
+type credentialsJSON struct {
+	Type        string           `json:"type"`
+	Installed   *oauthClientJSON `json:"installed"`
+	Web         *oauthClientJSON `json:"web"`
+	ClientId    string           `json:"client_id"`
+	ClientEmail string           `json:"client_email"`
+	PrivateKey  string           `json:"private_key"`
+}
+
+func parseCredentials(content []byte) (credentialsJSON, error) {
+	var creds credentialsJSON
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return creds, errors.New("the file is empty")
+	}
+	if err := json.Unmarshal(content, &creds); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return creds, fmt.Errorf("not valid JSON at byte %d: %w", syntaxErr.Offset, err)
+		}
+		return creds, fmt.Errorf("not a credentials file: %w", err)
+	}
+	return creds, nil
+}
+
+// check names what is missing or wrong, which ConfigFromJSON only reports
+// as "no credentials found".
+func (c credentialsJSON) check() error {
+	switch c.Type {
+	case "":
+	case "service_account":
+		if c.ClientEmail == "" {
+			return errors.New("the service account key is missing client_email")
+		}
+		if c.PrivateKey == "" {
+			return errors.New("the service account key is missing private_key")
+		}
+		return nil
+	case "authorized_user":
+		return errors.New("this looks like gcloud application default credentials, not an OAuth client; download the OAuth client from the Google Cloud console")
+	default:
+		return fmt.Errorf("unknown credentials type %q, expected an OAuth client", c.Type)
+	}
+
+	client := c.Installed
+	if client == nil {
+		client = c.Web
+	}
+	if client == nil {
+		if c.ClientId != "" {
+			return errors.New(`the client is missing its "installed" or "web" wrapper; use the file as downloaded from the Google Cloud console`)
+		}
+		return errors.New(`missing the "installed" or "web" key, this is not an OAuth client`)
+	}
+
+	missing := []string{}
+	for _, field := range []struct {
+		name  string
+		empty bool
+	}{
+		{"client_id", client.ClientId == ""},
+		{"client_secret", client.ClientSecret == ""},
+		{"auth_uri", client.AuthURI == ""},
+		{"token_uri", client.TokenURI == ""},
+		{"redirect_uris", len(client.RedirectURIs) == 0},
+	} {
+		if field.empty {
+			missing = append(missing, field.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// validateCredentials checks an OAuth client given while setting butler up.
+// Service account keys work with -credentials, but aren't what setup asks
+// for.
+func validateCredentials(content []byte) error {
+	creds, err := parseCredentials(content)
+	if err != nil {
+		return err
+	}
+	if creds.Type == "service_account" {
+		return errors.New("this looks like a service account key, not an OAuth client; create an OAuth client ID of type Desktop app instead")
+	}
+	return creds.check()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// writeDigest writes the dashboard under a title with the date.
+func writeDigest(w io.Writer, events []Event, messages []Message, now time.Time) {
+	fmt.Fprintln(w, bold("Digest for "+now.Format("Monday, 2 January 2006")))
+	fmt.Fprintln(w)
+	writeDashboard(w, events, messages)
+}
+
+// formatDigest is writeDigest as plain text, since mail clients would show
+// color escape sequences literally.
+func formatDigest(events []Event, messages []Message, now time.Time) string {
+	defer func(color bool) { useColor = color }(useColor)
+	useColor = false
+
+	var digest strings.Builder
+	writeDigest(&digest, events, messages, now)
+	return digest.String()
+}
+
+// sendDigest summarizes the rest of today's events and the messages matching
+// query, printing the summary or mailing it to emailTo when set.
+func sendDigest(ctx context.Context, client *http.Client, query MessageQuery, fetch FetchOptions, emailTo string) error {
+	events, messages, err := readDashboard(ctx, client, query, fetch)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if emailTo == "" {
+		writeDigest(os.Stdout, events, messages, now)
+		return nil
+	}
+	return sendMail(ctx, client, emailTo, "Digest for "+now.Format("Mon 2 Jan"), formatDigest(events, messages, now))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+type Draft struct {
+	Id        string
+	MessageId string
+	Subject   string
+	To        []string
+	Cc        []string
+	Snippet   string
+	Date      time.Time
+}
+
+// readDrafts gets up to max drafts with their headers but not their bodies.
+func readDrafts(ctx context.Context, client *http.Client, max int64) ([]Draft, error) {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := []*gmail.Draft{}
+	pageToken := ""
+	for int64(len(listed)) < max {
+		call := srv.Users.Drafts.List(mailbox).MaxResults(min(max-int64(len(listed)), 500)).Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := withRetry(ctx, call.Do)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve drafts: %w", err)
+		}
+		listed = append(listed, resp.Drafts...)
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	drafts := []Draft{}
+	for _, d := range listed {
+		draft, err := withRetry(ctx, srv.Users.Drafts.Get(mailbox, d.Id).Format("metadata").Context(ctx).Do)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve draft %s: %w", d.Id, err)
+		}
+		if draft.Message == nil {
+			continue
+		}
+		drafts = append(drafts, toDraft(draft))
+	}
+	return drafts, nil
+}
+
+func toDraft(draft *gmail.Draft) Draft {
+	message := toMessage(draft.Message)
+	result := Draft{Id: draft.Id, MessageId: message.Id, Subject: strings.TrimSpace(message.Subject), Snippet: message.Snippet, Date: message.Date}
+	for _, h := range draft.Message.Payload.Headers {
+		switch http.CanonicalHeaderKey(h.Name) {
+		case "To":
+			result.To = displayAddresses(parseAddressHeader(h.Value))
+		case "Cc":
+			result.Cc = displayAddresses(parseAddressHeader(h.Value))
+		}
+	}
+	return result
+}
+
+func displayAddresses(addresses []*mail.Address) []string {
+	formatted := []string{}
+	for _, a := range addresses {
+		if a.Name != "" {
+			formatted = append(formatted, a.Name+" <"+a.Address+">")
+		} else {
+			formatted = append(formatted, a.Address)
+		}
+	}
+	return formatted
+}
+
+func printDrafts(drafts []Draft) {
+	if len(drafts) == 0 {
+		fmt.Println("No drafts found.")
+		return
+	}
+
+	fmt.Println("")
+	for _, d := range drafts {
+		subject := d.Subject
+		if subject == "" {
+			subject = "(no subject)"
+		}
+		fmt.Println(bold("Subject: " + subject))
+		if len(d.To) > 0 {
+			fmt.Println("To:", strings.Join(d.To, ", "))
+		} else {
+			fmt.Println("To:", dim("(no recipients)"))
+		}
+		if len(d.Cc) > 0 {
+			fmt.Println("Cc:", strings.Join(d.Cc, ", "))
+		}
+		if !d.Date.IsZero() {
+			fmt.Println("Date:", formatTime(d.Date, "Mon, 2 Jan 2006 15:04"))
+		}
+		fmt.Println("Draft:", dim(d.Id))
+		fmt.Println("")
+	}
+}
+
+// sendDraft sends the draft with the given ID as it was saved.
+func sendDraft(ctx context.Context, client *http.Client, id string) error {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	return mutate("send draft "+id, func() error {
+		sent, err := srv.Users.Drafts.Send(mailbox, &gmail.Draft{Id: id}).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("unable to send draft %s: %w", id, err)
+		}
+		fmt.Println("Draft sent:", sent.Id)
+		return nil
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+	"google.golang.org/api/calendar/v3"
+)
+
+// eventStart describes when an event from the API starts, for prompts.
+func eventStart(event *calendar.Event) string {
+	if event.Start == nil {
+		return ""
+	}
+	if event.Start.DateTime != "" {
+		return formatTime(parseDate(event.Start.DateTime).In(calendarZone), "Mon 2 Jan 15:04")
+	}
+	return parseDate(event.Start.Date).Format("Mon 2 Jan")
+}
+
+// askDeleteSeries asks whether to delete a whole recurring series rather
+// than one occurrence. ok is false when the user doesn't want to delete
+// anything.
+func askDeleteSeries(summary string) (series bool, ok bool) {
+	fmt.Printf("%q is a recurring event. Delete this occurrence or the whole series? [o/s/N] ", summary)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "o", "occurrence":
+		return false, true
+	case "s", "series":
+		return true, true
+	}
+	return false, false
+}
+
+// deleteEvent deletes the event with ID nameOrId, or the one between from
+// and to with that summary. For an occurrence of a recurring event, series
+// deletes the whole series instead; in a terminal the user is asked.
+func deleteEvent(ctx context.Context, client *http.Client, calendarName string, nameOrId string, from time.Time, to time.Time, series bool, yes bool) error {
+	srv, err := getCalendarService(client)
+	if err != nil {
+		return err
+	}
+	calendarId, err := resolveCalendarId(ctx, srv, calendarName)
+	if err != nil {
+		return err
+	}
+	event, err := findEvent(ctx, client, srv, calendarName, calendarId, nameOrId, from, to)
+	if err != nil {
+		return fmt.Errorf("unable to find event: %w", err)
+	}
+
+	summary := strings.TrimSpace(event.Summary)
+	confirmed := yes || dryRun
+	if !confirmed && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return usageErrorf("refusing to delete an event without -yes")
+	}
+	// Picking the occurrence or the series confirms the deletion.
+	if event.RecurringEventId != "" && !series && !confirmed {
+		var ok bool
+		if series, ok = askDeleteSeries(summary); !ok {
+			return nil
+		}
+		confirmed = true
+	}
+
+	id, what := event.Id, fmt.Sprintf("event %q on %s", summary, eventStart(event))
+	if event.RecurringEventId != "" && series {
+		id, what = event.RecurringEventId, fmt.Sprintf("every occurrence of %q", summary)
+	} else if len(event.Recurrence) > 0 {
+		what = fmt.Sprintf("every occurrence of %q", summary)
+	}
+	if !confirmed && !confirm("Delete "+what+"?") {
+		return nil
+	}
+
+	return mutate(fmt.Sprintf("delete %s (%s)", what, id), func() error {
+		if err := srv.Events.Delete(calendarId, id).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("unable to delete event: %w", err)
+		}
+		fmt.Println("Deleted", what)
+		return nil
+	})
+}
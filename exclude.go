@@ -0,0 +1,58 @@
+package main
+
+import "strings"
+
+// parseExcludeFrom splits the comma separated -exclude-from value into
+// lower case addresses and domains.
+func parseExcludeFrom(value string) []string {
+	patterns := []string{}
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			patterns = append(patterns, strings.TrimPrefix(p, "@"))
+		}
+	}
+	return patterns
+}
+
+// excludeSearch has Gmail leave out senders matching patterns already,
+// which keeps -n meaning the number of messages shown.
+func excludeSearch(patterns []string) string {
+	terms := []string{}
+	for _, p := range patterns {
+		terms = append(terms, "-from:"+p)
+	}
+	return strings.Join(terms, " ")
+}
+
+// addressMatches reports whether address is the pattern address, or is in
+// the pattern domain or one of its subdomains.
+func addressMatches(address string, pattern string) bool {
+	address = strings.ToLower(address)
+	if strings.Contains(pattern, "@") {
+		return address == pattern
+	}
+	_, domain, found := strings.Cut(address, "@")
+	if !found {
+		domain = address
+	}
+	return domain == pattern || strings.HasSuffix(domain, "."+pattern)
+}
+
+// excludeSenders drops messages whose sender or Return-Path matches any of
+// patterns. The Return-Path catches mail sent on behalf of a domain, which
+// the search can't.
+func excludeSenders(messages []Message, patterns []string) []Message {
+	kept := []Message{}
+	for _, m := range messages {
+		excluded := false
+		for _, p := range patterns {
+			for _, address := range []string{m.SenderEmail, m.ReturnPath} {
+				excluded = excluded || address != "" && addressMatches(address, p)
+			}
+		}
+		if !excluded {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
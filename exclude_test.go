@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseExcludeFrom(t *testing.T) {
+	got := parseExcludeFrom(" Notifications@GitHub.com, @MailChimp.com,,example.org ")
+	want := []string{"notifications@github.com", "mailchimp.com", "example.org"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseExcludeFrom = %q, want %q", got, want)
+	}
+}
+
+func TestAddressMatches(t *testing.T) {
+	tests := []struct {
+		address string
+		pattern string
+		want    bool
+	}{
+		{"notifications@github.com", "notifications@github.com", true},
+		{"Notifications@GitHub.com", "notifications@github.com", true},
+		{"noreply@github.com", "notifications@github.com", false},
+		{"news@mailchimp.com", "mailchimp.com", true},
+		{"News@MailChimp.COM", "mailchimp.com", true},
+		{"bounce@us1.mailchimp.com", "mailchimp.com", true},
+		{"someone@notmailchimp.com", "mailchimp.com", false},
+		{"mailchimp.com", "mailchimp.com", true},
+		{"someone@mailchimp.com.evil.org", "mailchimp.com", false},
+	}
+	for _, test := range tests {
+		if got := addressMatches(test.address, test.pattern); got != test.want {
+			t.Errorf("addressMatches(%q, %q) = %v, want %v", test.address, test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestExcludeSenders(t *testing.T) {
+	messages := []Message{
+		{Id: "github", SenderEmail: "Notifications@GitHub.com"},
+		{Id: "newsletter", SenderEmail: "news@shop.example", ReturnPath: "bounce-123@mail.mailchimp.com"},
+		{Id: "friend", SenderEmail: "jane@example.com"},
+		{Id: "unparsed", Sender: "Mail Delivery System"},
+	}
+	kept := excludeSenders(messages, parseExcludeFrom("notifications@github.com,mailchimp.com"))
+	got := []string{}
+	for _, m := range kept {
+		got = append(got, m.Id)
+	}
+	if want := []string{"friend", "unparsed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("excludeSenders kept %q, want %q", got, want)
+	}
+}
+
+func TestRawExcluded(t *testing.T) {
+	patterns := parseExcludeFrom("notifications@github.com,mailchimp.com")
+	tests := []struct {
+		name string
+		raw  string
+		want bool
+	}{
+		{"from", "From: GitHub <Notifications@GitHub.com>\r\nSubject: PR\r\n\r\nBody\r\n", true},
+		{"return path", "Return-Path: <bounce-123@mail.mailchimp.com>\r\nFrom: Shop <news@shop.example>\r\n\r\nBody\r\n", true},
+		{"kept", "Return-Path: <jane@example.com>\r\nFrom: Jane <jane@example.com>\r\n\r\nBody\r\n", false},
+	}
+	for _, test := range tests {
+		if got := rawExcluded([]byte(test.raw), patterns); got != test.want {
+			t.Errorf("rawExcluded(%s) = %v, want %v", test.name, got, test.want)
+		}
+	}
+	if rawExcluded([]byte("From: news@mailchimp.com\r\n\r\n"), nil) {
+		t.Error("rawExcluded without patterns = true, want false")
+	}
+}
+
+func TestArchiveMessagesExcludeFrom(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	// m2 is sent on behalf of an excluded domain, which only its Return-Path
+	// shows, so the search can't leave it out.
+	var mu sync.Mutex
+	archived := []string{}
+	client := fakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/gmail/v1/users/me/labels":
+			io.WriteString(w, `{"labels": [{"id": "INBOX", "name": "INBOX"}]}`)
+		case r.URL.Path == "/gmail/v1/users/me/messages":
+			io.WriteString(w, `{"messages": [{"id": "m1"}, {"id": "m2"}, {"id": "m3"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/modify"):
+			mu.Lock()
+			archived = append(archived, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/messages/"), "/modify"))
+			mu.Unlock()
+			io.WriteString(w, `{}`)
+		case strings.HasPrefix(r.URL.Path, "/gmail/v1/users/me/messages/"):
+			id := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/messages/")
+			returnPath := "<" + id + "@example.com>"
+			if id == "m2" {
+				returnPath = "<bounce@mail.mailchimp.com>"
+			}
+			fmt.Fprintf(w, `{"id": %q, "payload": {"headers": [{"name": "From", "value": "%s@example.com"}, {"name": "Return-Path", "value": %q}]}}`, id, id, returnPath)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	query := MessageQuery{Labels: "INBOX", Max: 10, ExcludeFrom: parseExcludeFrom("mailchimp.com")}
+	if err := archiveMessages(context.Background(), client, "", query, 1); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(archived)
+	if want := []string{"m1", "m3"}; !reflect.DeepEqual(archived, want) {
+		t.Errorf("archived %q, want %q", archived, want)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecOptions is the -exec command run for every message.
+type ExecOptions struct {
+	Command string
+	Workers int
+	// Timeout limits every run of the command, 0 for no limit.
+	Timeout time.Duration
+}
+
+// shellCommand runs command with the shell of the platform.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// runExec runs the command once per message, with the message in
+// BUTLER_SUBJECT, BUTLER_SENDER and BUTLER_ID. Failures are logged without
+// stopping the other runs. The output of every run is printed in one piece so
+// concurrent runs don't interleave.
+func runExec(ctx context.Context, messages []Message, options ExecOptions) {
+	workers := options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for _, m := range messages {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(m Message) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			runCtx, cancel := ctx, context.CancelFunc(func() {})
+			if options.Timeout > 0 {
+				runCtx, cancel = context.WithTimeout(ctx, options.Timeout)
+			}
+			defer cancel()
+			cmd := shellCommand(runCtx, options.Command)
+			cmd.Env = append(os.Environ(),
+				"BUTLER_SUBJECT="+strings.TrimSpace(m.Subject),
+				"BUTLER_SENDER="+m.Sender,
+				"BUTLER_ID="+m.Id,
+			)
+			// Children of a killed shell can hold its output open.
+			cmd.WaitDelay = time.Second
+			output, err := cmd.CombinedOutput()
+
+			mu.Lock()
+			defer mu.Unlock()
+			os.Stdout.Write(output)
+			if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+				log.Printf("Command for message %s timed out after %s", m.Id, options.Timeout)
+			} else if err != nil {
+				log.Printf("Command for message %s failed: %v", m.Id, err)
+			}
+		}(m)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// Exit codes, documented in the README.
+const (
+	exitError = 1
+	// exitUsage matches what the flag package uses for unknown flags.
+	exitUsage     = 2
+	exitNoResults = 3
+	exitAuth      = 4
+	exitNetwork   = 5
+)
+
+// usageError is an error in how butler was invoked rather than in talking
+// to Google.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+func exitCode(err error) int {
+	var usage *usageError
+	var retrieve *oauth2.RetrieveError
+	var apiErr *googleapi.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, ErrNoCredentials), errors.Is(err, ErrTokenExpired), errors.Is(err, ErrAuthDenied),
+		errors.Is(err, ErrPassphraseRequired), errors.Is(err, ErrWrongPassphrase):
+		return exitAuth
+	case errors.As(err, &retrieve):
+		return exitAuth
+	case errors.As(err, &apiErr):
+		if apiErr.Code == 401 || apiErr.Code == 403 {
+			return exitAuth
+		}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return exitNetwork
+	}
+	return exitError
+}
+
+// fatal logs err, with advice for auth errors, and exits with the code for
+// its kind.
+func fatal(err error) {
+	log.Print(err)
+	if advice := authAdvice(err); advice != "" {
+		fmt.Fprintln(os.Stderr, advice)
+	}
+	os.Exit(exitCode(err))
+}
+
+// exitf logs a message and exits with code.
+func exitf(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// messageFields are the values -fields accepts, with the headers each needs.
+var messageFields = map[string][]string{
+	"subject": {"Subject"},
+	"sender":  {"From", "Return-Path"},
+	"date":    {"Date"},
+	"labels":  {},
+	"snippet": {},
+}
+
+// defaultFields is what printMessages shows without -fields.
+var defaultFields = []string{"subject", "sender", "date"}
+
+// parseFields splits the comma separated -fields value, keeping its order.
+func parseFields(value string) ([]string, error) {
+	fields := []string{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if _, ok := messageFields[field]; !ok {
+			return nil, usageErrorf("unknown field %q in -fields, use subject, sender, date, labels or snippet", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// fieldHeaders returns the headers needed to show fields. It is never nil,
+// as nil stands for full messages when fetching.
+func fieldHeaders(fields []string) []string {
+	headers := []string{}
+	for _, field := range fields {
+		for _, header := range messageFields[field] {
+			if !slices.Contains(headers, header) {
+				headers = append(headers, header)
+			}
+		}
+	}
+	return headers
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// FreeBusy is when a calendar is busy between two times, and the free gaps
+// in between.
+type FreeBusy struct {
+	Calendar string
+	Busy     []TimeRange
+	Free     []TimeRange
+}
+
+// mergeRanges sorts ranges and joins the ones that overlap or touch.
+func mergeRanges(ranges []TimeRange) []TimeRange {
+	sorted := append([]TimeRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	merged := []TimeRange{}
+	for _, r := range sorted {
+		if last := len(merged) - 1; last >= 0 && !r.Start.After(merged[last].End) {
+			if r.End.After(merged[last].End) {
+				merged[last].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// freeRanges returns the gaps between busy ranges within from and to.
+func freeRanges(busy []TimeRange, from time.Time, to time.Time) []TimeRange {
+	free := []TimeRange{}
+	start := from
+	for _, b := range mergeRanges(busy) {
+		if b.Start.After(start) {
+			free = append(free, TimeRange{Start: start, End: b.Start})
+		}
+		if b.End.After(start) {
+			start = b.End
+		}
+	}
+	if to.After(start) {
+		free = append(free, TimeRange{Start: start, End: to})
+	}
+	return free
+}
+
+// readFreeBusy asks for the busy times of the comma separated calendars
+// between from and to. With several calendars a last entry combines them, so
+// its free ranges are when all of them are free.
+func readFreeBusy(ctx context.Context, client *http.Client, calendarNames string, from time.Time, to time.Time) ([]FreeBusy, error) {
+	srv, err := getCalendarService(client)
+	if err != nil {
+		return nil, err
+	}
+	names := strings.Split(calendarNames, ",")
+	request := &calendar.FreeBusyRequest{TimeMin: from.Format(time.RFC3339), TimeMax: to.Format(time.RFC3339)}
+	ids := []string{}
+	for _, name := range names {
+		id, err := resolveCalendarId(ctx, srv, strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		request.Items = append(request.Items, &calendar.FreeBusyRequestItem{Id: id})
+	}
+
+	resp, err := withRetry(ctx, srv.Freebusy.Query(request).Context(ctx).Do)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve free/busy information: %w", err)
+	}
+
+	result := []FreeBusy{}
+	all := []TimeRange{}
+	for i, id := range ids {
+		cal, ok := resp.Calendars[id]
+		if !ok {
+			return nil, fmt.Errorf("no free/busy information for calendar %q", names[i])
+		}
+		if len(cal.Errors) > 0 {
+			return nil, fmt.Errorf("unable to retrieve free/busy information for calendar %q: %s", names[i], cal.Errors[0].Reason)
+		}
+		busy := []TimeRange{}
+		for _, period := range cal.Busy {
+			busy = append(busy, TimeRange{Start: parseDate(period.Start), End: parseDate(period.End)})
+		}
+		all = append(all, busy...)
+		result = append(result, FreeBusy{Calendar: strings.TrimSpace(names[i]), Busy: mergeRanges(busy), Free: freeRanges(busy, from, to)})
+	}
+	if len(ids) > 1 {
+		result = append(result, FreeBusy{Calendar: "All calendars", Busy: mergeRanges(all), Free: freeRanges(all, from, to)})
+	}
+	return result, nil
+}
+
+func printFreeBusy(calendars []FreeBusy) {
+	const layout = "Mon 2 Jan 15:04"
+	type block struct {
+		TimeRange
+		busy bool
+	}
+	for _, c := range calendars {
+		fmt.Println("")
+		fmt.Println(bold(c.Calendar))
+		blocks := []block{}
+		for _, r := range c.Busy {
+			blocks = append(blocks, block{r, true})
+		}
+		for _, r := range c.Free {
+			blocks = append(blocks, block{r, false})
+		}
+		sort.Slice(blocks, func(i, j int) bool { return blocks[i].Start.Before(blocks[j].Start) })
+		for _, b := range blocks {
+			line := fmt.Sprintf("  %s - %s", b.Start.In(calendarZone).Format(layout), b.End.In(calendarZone).Format(layout))
+			if b.busy {
+				fmt.Println(dim(line + "  busy"))
+			} else {
+				fmt.Println(line + "  free")
+			}
+		}
+	}
+	fmt.Println("")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	whitespacePattern     = regexp.MustCompile(`[ \t\r\n\f]+`)
+	trailingSpacesPattern = regexp.MustCompile(` +\n`)
+	blankLinesPattern     = regexp.MustCompile(`\n{3,}`)
+)
+
+// textWriter builds the text of an HTML body, turning the blocks it closes
+// into line breaks that are only written once more text follows.
+type textWriter struct {
+	b      strings.Builder
+	breaks int
+}
+
+// lineBreak asks for n line breaks before the next text: 1 for a new line, 2
+// for a new paragraph.
+func (w *textWriter) lineBreak(n int) {
+	w.breaks = max(w.breaks, n)
+}
+
+// write writes s as is, after any pending line breaks.
+func (w *textWriter) write(s string) {
+	if w.b.Len() > 0 && w.breaks > 0 {
+		// Breaks count from the end of the current line.
+		current := w.b.String()
+		trailing := len(current) - len(strings.TrimRight(current, "\n"))
+		if n := w.breaks - trailing; n > 0 {
+			w.b.WriteString(strings.Repeat("\n", n))
+		}
+	}
+	w.breaks = 0
+	w.b.WriteString(s)
+}
+
+// text writes s with whitespace collapsed as a browser would.
+func (w *textWriter) text(s string) {
+	s = whitespacePattern.ReplaceAllString(s, " ")
+	current := w.b.String()
+	if w.breaks > 0 || current == "" || strings.HasSuffix(current, " ") || strings.HasSuffix(current, "\n") {
+		s = strings.TrimLeft(s, " ")
+	}
+	if s != "" {
+		w.write(s)
+	}
+}
+
+// htmlList is an open <ul> or <ol>, numbering its items when ordered.
+type htmlList struct {
+	ordered bool
+	items   int
+}
+
+// htmlToText renders an HTML body as readable plain text: paragraphs and
+// line breaks are kept, lists get bullets or numbers and links are followed
+// by their URL.
+func htmlToText(s string) string {
+	w := &textWriter{}
+	z := html.NewTokenizer(strings.NewReader(s))
+	skip, pre := 0, 0
+	lists := []*htmlList{}
+	href, linkStart := "", 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				debugf("Unable to parse HTML body: %v", z.Err())
+			}
+			break
+		}
+		token := z.Token()
+		switch tt {
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			// Non-breaking spaces are mostly used for layout in mail.
+			text := strings.ReplaceAll(token.Data, "\u00a0", " ")
+			if pre > 0 {
+				w.write(text)
+			} else {
+				w.text(text)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.DataAtom {
+			case atom.Head, atom.Style, atom.Script, atom.Title:
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case atom.Br:
+				w.write("\n")
+			case atom.P, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Blockquote, atom.Table:
+				w.lineBreak(2)
+			case atom.Div, atom.Tr, atom.Section, atom.Article, atom.Header, atom.Footer:
+				w.lineBreak(1)
+			case atom.Hr:
+				w.lineBreak(1)
+				w.write("---")
+				w.lineBreak(1)
+			case atom.Pre:
+				w.lineBreak(2)
+				pre++
+			case atom.Td, atom.Th:
+				w.text(" ")
+			case atom.Ul, atom.Ol:
+				if len(lists) == 0 {
+					w.lineBreak(2)
+				} else {
+					w.lineBreak(1)
+				}
+				lists = append(lists, &htmlList{ordered: token.DataAtom == atom.Ol})
+			case atom.Li:
+				w.lineBreak(1)
+				marker := "- "
+				if len(lists) > 0 {
+					list := lists[len(lists)-1]
+					list.items++
+					if list.ordered {
+						marker = strconv.Itoa(list.items) + ". "
+					}
+				}
+				w.write(strings.Repeat("  ", max(len(lists)-1, 0)) + marker)
+			case atom.A:
+				href, linkStart = "", w.b.Len()
+				for _, attr := range token.Attr {
+					if attr.Key == "href" {
+						href = strings.TrimSpace(attr.Val)
+					}
+				}
+			}
+		case html.EndTagToken:
+			switch token.DataAtom {
+			case atom.Head, atom.Style, atom.Script, atom.Title:
+				skip = max(skip-1, 0)
+			case atom.P, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Blockquote, atom.Table:
+				w.lineBreak(2)
+			case atom.Div, atom.Tr, atom.Li, atom.Section, atom.Article, atom.Header, atom.Footer:
+				w.lineBreak(1)
+			case atom.Pre:
+				pre = max(pre-1, 0)
+				w.lineBreak(2)
+			case atom.Ul, atom.Ol:
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				if len(lists) == 0 {
+					w.lineBreak(2)
+				} else {
+					w.lineBreak(1)
+				}
+			case atom.A:
+				writeLink(w, href, linkStart)
+				href = ""
+			}
+		}
+	}
+
+	text := trailingSpacesPattern.ReplaceAllString(w.b.String(), "\n")
+	text = blankLinesPattern.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// writeLink adds the URL after the text of a link that ended, unless the
+// text already is the URL. Links to anchors and scripts are left out.
+func writeLink(w *textWriter, href string, start int) {
+	if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") && !strings.HasPrefix(href, "mailto:") {
+		return
+	}
+	text := ""
+	if start <= w.b.Len() {
+		text = strings.TrimSpace(w.b.String()[start:])
+	}
+	switch {
+	case text == "":
+		w.text(" " + href)
+	case text == href || text == strings.TrimPrefix(href, "mailto:") || "http://"+text == href || "https://"+text == href:
+	default:
+		w.write(" (" + href + ")")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			"paragraphs and line breaks",
+			"<p>Hi Jane,</p><p>The report is ready.<br>Let me know what you think.</p><p>Thanks,<br/>Sam</p>",
+			"Hi Jane,\n\nThe report is ready.\nLet me know what you think.\n\nThanks,\nSam",
+		},
+		{
+			"links",
+			`<p>Read the <a href="https://example.com/report">full report</a> or visit <a href="https://example.com">https://example.com</a>. <a href="#top">Back to top</a></p>`,
+			"Read the full report (https://example.com/report) or visit https://example.com. Back to top",
+		},
+		{
+			"mailto link",
+			`Write to <a href="mailto:support@example.com">support@example.com</a> or <a href="mailto:sales@example.com">sales</a>.`,
+			"Write to support@example.com or sales (mailto:sales@example.com).",
+		},
+		{
+			"lists",
+			"<p>Agenda:</p><ol><li>Budget</li><li>Hiring<ul><li>Backend</li><li>Design</li></ul></li><li>Other</li></ol><p>See you</p>",
+			"Agenda:\n\n1. Budget\n2. Hiring\n  - Backend\n  - Design\n3. Other\n\nSee you",
+		},
+		{
+			"script and style dropped",
+			"<html><head><title>Newsletter</title><style>p { color: red; }</style></head><body><script>track('open');</script><p>Hello&nbsp;there &amp; welcome</p></body></html>",
+			"Hello there & welcome",
+		},
+		{
+			"whitespace collapsed",
+			"<div>\n  <span>Your   order</span>\n  <span>has shipped</span>\n</div>",
+			"Your order has shipped",
+		},
+		{
+			"preformatted",
+			"<p>Output:</p><pre>  line 1\n  line 2</pre>",
+			"Output:\n\n  line 1\n  line 2",
+		},
+		{
+			"table cells",
+			"<table><tr><td>Total</td><td>$12.00</td></tr><tr><td>Tax</td><td>$1.00</td></tr></table>",
+			"Total $12.00\nTax $1.00",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := htmlToText(test.html); got != test.want {
+				t.Errorf("htmlToText(%q) =\n%q\nwant\n%q", test.html, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// foldICSLine splits content lines longer than 75 octets as required by
+// RFC 5545, without breaking up multibyte characters.
+func foldICSLine(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+func writeICS(w io.Writer, events []Event) error {
+	bw := bufio.NewWriter(w)
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	write := func(line string) {
+		bw.WriteString(foldICSLine(line))
+	}
+
+	write("BEGIN:VCALENDAR")
+	write("VERSION:2.0")
+	write("PRODID:-//butler//butler//EN")
+	for _, event := range events {
+		write("BEGIN:VEVENT")
+		write("UID:" + icsEscaper.Replace(event.Id) + "@butler")
+		write("DTSTAMP:" + stamp)
+		write("SUMMARY:" + icsEscaper.Replace(event.Summary))
+		if event.AllDay {
+			start := eventDay(event)
+			end := start.AddDate(0, 0, 1)
+			if event.EndDate != "" {
+				end = parseDate(event.EndDate)
+			}
+			write("DTSTART;VALUE=DATE:" + start.Format("20060102"))
+			write("DTEND;VALUE=DATE:" + end.Format("20060102"))
+		} else {
+			write("DTSTART:" + event.StartTime.UTC().Format("20060102T150405Z"))
+			if event.EndDateTime != "" {
+				write("DTEND:" + parseDate(event.EndDateTime).UTC().Format("20060102T150405Z"))
+			}
+		}
+		write("END:VEVENT")
+	}
+	write("END:VCALENDAR")
+	return bw.Flush()
+}
+
+func exportICS(events []Event, path string) error {
+	if path == "" {
+		return writeICS(os.Stdout, events)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := writeICS(f, events); err != nil {
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+	"google.golang.org/api/gmail/v1"
+)
+
+// findLabel looks name up exactly, then ignoring case unless
+// -case-sensitive is set, so an exact match wins over labels that only differ
+// in case. The error suggests the closest name for typos.
+func findLabel(labels []Label, name string) (Label, error) {
+	for _, l := range labels {
+		if l.Name == name {
+			return l, nil
+		}
+	}
+	for _, l := range labels {
+		if !caseSensitiveLabels && strings.EqualFold(l.Name, name) {
+			return l, nil
+		}
+	}
+	if suggestion := closestLabel(labels, name); suggestion != "" {
+		return Label{}, usageErrorf("label %q not found; did you mean %q?", name, suggestion)
+	}
+	return Label{}, usageErrorf("label %q not found", name)
+}
+
+// closestLabel returns the label name closest to name, or "" when none is
+// close enough to be a typo.
+func closestLabel(labels []Label, name string) string {
+	best, bestDistance := "", len([]rune(name))/2+1
+	for _, l := range labels {
+		if d := levenshtein(strings.ToLower(l.Name), strings.ToLower(name)); d <= bestDistance {
+			best, bestDistance = l.Name, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur := make([]int, len(t)+1)
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(t)]
+}
+
+// pickedLabels remembers labels chosen with pickLabel, so -watch only asks
+// once.
+var pickedLabels = map[string]Label{}
+
+// selectLabel is findLabel for labels to search. When a name isn't found and
+// butler runs in a terminal, the user picks one of the labels instead.
+func selectLabel(labels []Label, name string) (Label, error) {
+	label, err := findLabel(labels, name)
+	if err == nil {
+		return label, nil
+	}
+	if picked, ok := pickedLabels[name]; ok {
+		return picked, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return Label{}, err
+	}
+	label, ok := pickLabel(labels, name)
+	if !ok {
+		return Label{}, err
+	}
+	pickedLabels[name] = label
+	return label, nil
+}
+
+// pickLabel lists labels on stderr, keeping stdout clean for -json, and
+// reads the number of the one to use.
+func pickLabel(labels []Label, name string) (Label, bool) {
+	sorted := append([]Label{}, labels...)
+	sort.Slice(sorted, func(i, j int) bool { return strings.ToLower(sorted[i].Name) < strings.ToLower(sorted[j].Name) })
+	fmt.Fprintf(os.Stderr, "Label %q not found. Available labels:\n", name)
+	for i, l := range sorted {
+		fmt.Fprintf(os.Stderr, "%4d  %s\n", i+1, l.Name)
+	}
+	fmt.Fprintf(os.Stderr, "Pick a label [1-%d]: ", len(sorted))
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return Label{}, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || n < 1 || n > len(sorted) {
+		return Label{}, false
+	}
+	return sorted[n-1], true
+}
+
+// manageLabels creates, deletes or renames labels and prints the resulting
+// label list.
+func manageLabels(ctx context.Context, client *http.Client, create string, remove string, rename string, newName string) error {
+	if rename != "" && newName == "" {
+		return usageErrorf("-rename-label requires -new-name")
+	}
+
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	user := mailbox
+
+	if create != "" {
+		err := mutate(fmt.Sprintf("create label %q", create), func() error {
+			label, err := srv.Users.Labels.Create(user, &gmail.Label{Name: create}).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("unable to create label %q: %w", create, err)
+			}
+			fmt.Println("Created label:", label.Name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if remove != "" || rename != "" {
+		labels, err := listLabels(ctx, srv)
+		if err != nil {
+			return err
+		}
+		if remove != "" {
+			label, err := findLabel(labels, remove)
+			if err != nil {
+				return err
+			}
+			err = mutate(fmt.Sprintf("delete label %q (%s)", label.Name, label.Id), func() error {
+				if err := srv.Users.Labels.Delete(user, label.Id).Context(ctx).Do(); err != nil {
+					return fmt.Errorf("unable to delete label %q: %w", remove, err)
+				}
+				fmt.Println("Deleted label:", label.Name)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if rename != "" {
+			label, err := findLabel(labels, rename)
+			if err != nil {
+				return err
+			}
+			err = mutate(fmt.Sprintf("rename label %q (%s) to %q", label.Name, label.Id, newName), func() error {
+				if _, err := srv.Users.Labels.Patch(user, label.Id, &gmail.Label{Name: newName}).Context(ctx).Do(); err != nil {
+					return fmt.Errorf("unable to rename label %q: %w", rename, err)
+				}
+				fmt.Println("Renamed label:", label.Name, "->", newName)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	labels, err := listLabels(ctx, srv)
+	if err != nil {
+		return err
+	}
+	printLabels(labels)
+	return nil
+}
+
+func printLabels(labels []Label) {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+
+	fmt.Println("")
+	for _, l := range labels {
+		fmt.Println(l.Name)
+	}
+}
+
+type LabelCount struct {
+	Name   string
+	Total  int64
+	Unread int64
+}
+
+// readLabelCounts gets message counts for every label, or only for the comma
+// separated names when given, busiest labels first.
+func readLabelCounts(ctx context.Context, client *http.Client, names string) ([]LabelCount, error) {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := listLabels(ctx, srv)
+	if err != nil {
+		return nil, err
+	}
+	if names != "" {
+		selected := []Label{}
+		for _, name := range strings.Split(names, ",") {
+			label, err := selectLabel(labels, name)
+			if err != nil {
+				return nil, err
+			}
+			selected = append(selected, label)
+		}
+		labels = selected
+	}
+
+	counts := []LabelCount{}
+	for _, l := range labels {
+		label, err := withRetry(ctx, srv.Users.Labels.Get(mailbox, l.Id).Context(ctx).Do)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve label %q: %w", l.Name, err)
+		}
+		counts = append(counts, LabelCount{Name: label.Name, Total: label.MessagesTotal, Unread: label.MessagesUnread})
+	}
+
+	sort.SliceStable(counts, func(i, j int) bool {
+		if counts[i].Unread != counts[j].Unread {
+			return counts[i].Unread > counts[j].Unread
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts, nil
+}
+
+func printLabelCounts(counts []LabelCount) {
+	fmt.Println("")
+	for _, c := range counts {
+		line := fmt.Sprintf("%-30s %6d unread %8d total", c.Name, c.Unread, c.Total)
+		if c.Unread > 0 {
+			line = bold(line)
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Diagnostics go to stderr and are filtered by verbosity, results always go
+// to stdout.
+const (
+	levelQuiet = iota
+	levelInfo
+	levelDebug
+)
+
+var verbosity = levelInfo
+
+func infof(format string, args ...any) {
+	if verbosity >= levelInfo {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+func debugf(format string, args ...any) {
+	if verbosity >= levelDebug {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// loggingTransport logs every API request at debug level.
+type loggingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		debugf("%s %s failed after %s: %v", req.Method, req.URL.Redacted(), time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+	debugf("%s %s %d in %s", req.Method, req.URL.Redacted(), resp.StatusCode, time.Since(start).Round(time.Millisecond))
+	return resp, nil
+}
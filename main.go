@@ -2,423 +2,2651 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/term"
+	"golang.org/x/text/encoding/htmlindex"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/gmail/v1"
 	"google.golang.org/api/option"
 )
 
+var authPort int
+var profile string
+var credentialsFile string
+var deviceAuth bool
+var dryRun bool
+var relativeTimes bool
+var caseSensitiveLabels bool
+
+// calendarZone is where event times are shown, set with -tz.
+var calendarZone = time.Local
+
+// soonThreshold highlights events starting within it, set with -soon.
+var soonThreshold time.Duration
+var mailbox = "me"
+
 type Message struct {
-	Id      string
-	Labels  []string
-	Subject string
-	Sender  string
+	Id          string
+	ThreadId    string
+	Labels      []string
+	Subject     string
+	Sender      string
+	SenderName  string
+	SenderEmail string
+	ReturnPath  string
+	Body        string
+	Snippet     string
+	Date        time.Time
+	Attachments []Attachment
+}
+
+type MessageQuery struct {
+	Labels string
+	// AnyLabel matches messages with any of Labels instead of all of them.
+	AnyLabel bool
+	Query    string
+	Max      int64
+	All      bool
+	// ExcludeFrom drops messages from these addresses and domains.
+	ExcludeFrom []string
+	// Page is the page of PerPage messages readMailPage gets, following
+	// the listing as far as needed rather than up to Max.
+	Page    int
+	PerPage int
+}
+
+// FetchOptions controls how listed messages are retrieved.
+type FetchOptions struct {
+	Workers  int
+	UseCache bool
+	Snippet  bool
+	// Headers replaces the headers fetched with Snippet set, for -fields.
+	Headers []string
+}
+
+// metadataHeaders is nil when full messages are needed, otherwise the
+// headers to fetch without the body.
+func (f FetchOptions) metadataHeaders() []string {
+	if !f.Snippet {
+		return nil
+	}
+	if f.Headers != nil {
+		return f.Headers
+	}
+	return snippetHeaders
 }
 
 type Label struct {
-	Id   string
-	Name string
+	Id              string
+	Name            string
+	TextColor       string
+	BackgroundColor string
 }
 
 type Event struct {
+	Id          string
 	Summary     string
 	StartDate   string
 	StartTime   time.Time
 	EndDateTime string
+	EndDate     string
+	AllDay      bool
 }
 
 func getHomeDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	return home
 }
 
-func getCacheDir() string {
-	homeDir := getHomeDir()
-	cacheDir := homeDir + "/.butler"
-	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
-		os.Mkdir(cacheDir, 0755)
+// getConfigDir returns the directory for the config file, credentials and
+// tokens, such as ~/.config/butler on Linux or %AppData%\butler on Windows.
+func getConfigDir() (string, error) {
+	legacyDir := getHomeDir() + "/.butler"
+	configDir := legacyDir
+	if userConfigDir, err := os.UserConfigDir(); err == nil {
+		configDir = userConfigDir + "/butler"
+	} else {
+		debugf("Unable to find the user config directory, using %s: %v", legacyDir, err)
 	}
-	return cacheDir
+	if _, err := os.Stat(configDir); os.IsNotExist(err) {
+		if configDir != legacyDir && migrateLegacyDir(legacyDir, configDir) {
+			return configDir, nil
+		}
+		if err := os.MkdirAll(configDir, 0700); err != nil {
+			return "", fmt.Errorf("unable to create config directory: %w", err)
+		}
+	}
+	return configDir, nil
 }
 
-func getCredentialsPath() string {
-	cacheDir := getCacheDir()
-	credentialsPath := cacheDir + "/credentials.json"
-	return credentialsPath
+// getCacheDir returns the directory for data that is safe to delete, such
+// as ~/.cache/butler on Linux.
+func getCacheDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		debugf("Unable to find the user cache directory: %v", err)
+		userCacheDir = os.TempDir()
+	}
+	cacheDir := userCacheDir + "/butler"
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return "", fmt.Errorf("unable to create cache directory: %w", err)
+	}
+	return cacheDir, nil
 }
 
-func getTokenPath() string {
-	cacheDir := getCacheDir()
-	tokenPath := cacheDir + "/token.json"
-	return tokenPath
+// migrateLegacyDir moves ~/.butler, where everything was kept before the
+// platform directories were used, to configDir. Message caches are dropped
+// rather than moved as they are rebuilt on demand.
+func migrateLegacyDir(legacyDir string, configDir string) bool {
+	if _, err := os.Stat(legacyDir); err != nil {
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(configDir), 0700); err != nil {
+		debugf("Unable to create %s: %v", filepath.Dir(configDir), err)
+		return false
+	}
+	if err := os.Rename(legacyDir, configDir); err != nil {
+		log.Printf("Unable to move %s to %s: %v", legacyDir, configDir, err)
+		return false
+	}
+	if entries, err := os.ReadDir(configDir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				os.RemoveAll(configDir + "/" + entry.Name() + "/cache")
+			}
+		}
+	}
+	infof("Moved %s to %s", legacyDir, configDir)
+	return true
 }
 
-func getClient(config *oauth2.Config) *http.Client {
-	tokFile := getTokenPath()
-	tok, err := tokenFromFile(tokFile)
+func getProfileDir() (string, error) {
+	configDir, err := getConfigDir()
 	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
+		return "", err
 	}
-	return config.Client(context.Background(), tok)
+	profileDir := configDir + "/" + profile
+	if _, err := os.Stat(profileDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(profileDir, 0700); err != nil {
+			return "", fmt.Errorf("unable to create profile directory: %w", err)
+		}
+		if profile == "default" {
+			migrateLegacyFiles(configDir, profileDir)
+		}
+	}
+	return profileDir, nil
 }
 
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Println("Authenticate this app in the browser")
-
-	exec.Command("open", authURL).Start()
-
-	var authCode string
-	shutdownChan := make(chan struct{})
-	server := &http.Server{Addr: ":3333"}
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		io.WriteString(w, "Authentication successful! You can close this tab.")
-		authCode = r.URL.Query().Get("code")
-		shutdownChan <- struct{}{}
-	})
-
-	go func() {
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			fmt.Printf("HTTP server ListenAndServe: %v", err)
+// migrateLegacyFiles moves credentials and tokens saved before profiles
+// existed into the default profile.
+func migrateLegacyFiles(configDir string, profileDir string) {
+	for _, name := range []string{"credentials.json", "token.json"} {
+		if _, err := os.Stat(configDir + "/" + name); err == nil {
+			os.Rename(configDir+"/"+name, profileDir+"/"+name)
 		}
-	}()
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	select {
-	case <-sigChan:
-	case <-shutdownChan:
 	}
+}
 
-	if err := server.Shutdown(context.Background()); err != nil {
-		fmt.Printf("HTTP server Shutdown: %v", err)
+func listProfiles() error {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return err
 	}
-
-	tok, err := config.Exchange(context.Background(), authCode)
+	entries, err := os.ReadDir(configDir)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		return fmt.Errorf("unable to read profiles: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if entry.Name() == profile {
+			fmt.Println("*", entry.Name())
+		} else {
+			fmt.Println(" ", entry.Name())
+		}
 	}
-	return tok
+	return nil
 }
 
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
+// readCredentials returns the OAuth client JSON. BUTLER_CREDENTIALS may hold
+// either the JSON itself or a path to it.
+func readCredentials() ([]byte, error) {
+	if credentialsFile == "" && os.Getenv("BUTLER_CREDENTIALS_B64") != "" {
+		return decodeBase64Env("BUTLER_CREDENTIALS_B64")
+	}
+	env := strings.TrimSpace(os.Getenv("BUTLER_CREDENTIALS"))
+	if credentialsFile == "" && strings.HasPrefix(env, "{") {
+		return []byte(env), nil
+	}
+	path, err := getCredentialsPath()
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %w", ErrNoCredentials, err)
+	}
+	return b, err
 }
 
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Println("Saving credential file to: ", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+// decodeBase64Env decodes the standard base64 in an environment variable,
+// with or without padding.
+func decodeBase64Env(name string) ([]byte, error) {
+	value := strings.TrimRight(strings.TrimSpace(os.Getenv(name)), "=")
+	data, err := base64.RawStdEncoding.DecodeString(value)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return nil, fmt.Errorf("invalid base64 in %s: %w", name, err)
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	return data, nil
 }
 
-func read_mail(b []byte, numberOfMessages *int64, labelsToSearch *string) {
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, gmail.MailGoogleComScope, calendar.CalendarReadonlyScope)
+func getCredentialsPath() (string, error) {
+	if credentialsFile != "" {
+		return credentialsFile, nil
+	}
+	if env := os.Getenv("BUTLER_CREDENTIALS"); env != "" {
+		return env, nil
+	}
+	profileDir, err := getProfileDir()
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		return "", err
 	}
-	client := getClient(config)
+	credentialsPath := profileDir + "/credentials.json"
+	return credentialsPath, nil
+}
 
-	ctx := context.Background()
-	srv, err := gmail.NewService(ctx, option.WithHTTPClient(client))
+func getTokenPath() (string, error) {
+	profileDir, err := getProfileDir()
 	if err != nil {
-		log.Fatalf("Unable to retrieve Gmail client: %v", err)
+		return "", err
 	}
+	tokenPath := profileDir + "/token.json"
+	return tokenPath, nil
+}
 
-	labels := []Label{}
-	resp, err := srv.Users.Labels.List("me").Do()
+// getClient authorizes with the saved token, or asks the user to log in.
+// It authenticates again when the saved token lacks any of the scopes in
+// config, asking for those together with the ones already granted. redirect
+// is the callback registered for web application credentials, nil for
+// desktop apps which can use any loopback port.
+func getClient(config *oauth2.Config, redirect *url.URL) (*http.Client, error) {
+	ctx := oauthContext()
+	if os.Getenv("BUTLER_TOKEN_B64") != "" {
+		return getClientFromEnv(ctx, config)
+	}
+
+	tokFile, err := getTokenPath()
 	if err != nil {
-		log.Fatalf("Unable to retrieve labels: %v", err)
+		return nil, err
 	}
-	for _, l := range resp.Labels {
-		labels = append(labels, Label{Id: l.Id, Name: l.Name})
+	tok, granted, err := tokenFromFile(tokFile)
+	// Logging in again would replace a token that only needs the right
+	// passphrase.
+	if errors.Is(err, ErrPassphraseRequired) || errors.Is(err, ErrWrongPassphrase) {
+		return nil, err
 	}
-
-	user := "me"
-	convertedLabelsToSearch := []string{}
-	for _, label := range strings.Split(*labelsToSearch, ",") {
-		for _, l := range labels {
-			if l.Name == label {
-				convertedLabelsToSearch = append(convertedLabelsToSearch, l.Id)
-				break
-			}
-		}
+	if err == nil && !hasScopes(granted, config.Scopes) {
+		infof("This command needs more access than the saved token has, authenticate again to grant it.")
+		config.Scopes = unionScopes(granted, config.Scopes)
+		err = errors.New("missing scopes")
 	}
-	r, err := srv.Users.Messages.List(user).LabelIds(convertedLabelsToSearch...).MaxResults(*numberOfMessages).Do()
 	if err != nil {
-		log.Fatalf("Unable to retrieve messages: %v", err)
-	}
-
-	messages := []Message{}
-
-	if len(r.Messages) == 0 {
-		fmt.Println("No messages found.")
-		return
-	} else {
-		for _, m := range r.Messages {
-			msg, err := srv.Users.Messages.Get(user, m.Id).Format("full").Do()
-			if err != nil {
-				log.Printf("Unable to retrieve message %v: %v", m.Id, err)
-				continue
-			}
-			subject := ""
-			from := ""
-			for _, header := range msg.Payload.Headers {
-				if header.Name == "Subject" {
-					subject = header.Value
-					break
-				}
-				if header.Name == "Return-Path" {
-					from = strings.ReplaceAll(strings.Split(header.Value, "@")[1], ">", "")
-				}
-				if header.Name == "From" {
-					from = header.Value
-				}
+		if deviceAuth {
+			// Google only offers the device flow to "TVs and Limited Input
+			// devices" clients.
+			if redirect != nil {
+				return nil, errors.New("device authorization doesn't work with web application credentials, create a \"TVs and Limited Input devices\" OAuth client instead")
 			}
-			messages = append(messages, Message{Id: m.Id, Labels: msg.LabelIds, Subject: subject, Sender: from})
+			tok, err = getTokenFromDevice(config)
+		} else {
+			tok, err = getTokenFromWeb(config, redirect)
+		}
+		if err != nil {
+			return nil, err
 		}
+		granted = config.Scopes
+		if scope, ok := tok.Extra("scope").(string); ok && scope != "" {
+			granted = strings.Fields(scope)
+		}
+		saveToken(tokFile, tok, granted)
 	}
+	source := &savingTokenSource{source: expiryTokenSource{config.TokenSource(ctx, tok)}, path: tokFile, scopes: granted, last: tok}
+	return oauth2.NewClient(ctx, oauth2.ReuseTokenSource(tok, source)), nil
+}
 
-	fmt.Println("")
-	for _, m := range messages {
-		fmt.Println("\033[1mSubject:", strings.TrimSpace(m.Subject), "\033[0m")
-		fmt.Println("Sender:", m.Sender)
-		fmt.Println("")
+// getClientFromEnv uses the token in BUTLER_TOKEN_B64, as saved in
+// token.json, without ever writing it to disk. Refreshed access tokens only
+// live for the run.
+func getClientFromEnv(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
+	data, err := decodeBase64Env("BUTLER_TOKEN_B64")
+	if err != nil {
+		return nil, err
+	}
+	tok, granted, err := decodeToken(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token in BUTLER_TOKEN_B64: %w", err)
+	}
+	if !hasScopes(granted, config.Scopes) {
+		return nil, fmt.Errorf("the token in BUTLER_TOKEN_B64 lacks the scopes this command needs: %s", strings.Join(config.Scopes, " "))
 	}
+	return oauth2.NewClient(ctx, expiryTokenSource{config.TokenSource(ctx, tok)}), nil
 }
 
-func parseDate(dateStr string) time.Time {
-	t, err := time.Parse(time.RFC3339, dateStr)
+// savingTokenSource writes tokens back to disk whenever the wrapped source
+// hands out a new one, so refreshed access tokens survive between runs.
+type savingTokenSource struct {
+	source oauth2.TokenSource
+	path   string
+	scopes []string
+	last   *oauth2.Token
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.source.Token()
 	if err != nil {
-		// If it fails to parse as date-time, try parsing as date-only
-		t, err = time.Parse("2006-01-02", dateStr)
-		if err != nil {
-			fmt.Println("Error parsing date:", err)
-			return time.Time{}
-		}
+		return nil, err
 	}
-	return t
+	if s.last == nil || tok.AccessToken != s.last.AccessToken {
+		saveToken(s.path, tok, s.scopes)
+		s.last = tok
+	}
+	return tok, nil
 }
 
-func sortEvents(events []Event) {
-	sort.Slice(events, func(i, j int) bool {
-		return events[i].StartTime.Before(events[j].StartTime)
-	})
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
 }
 
-func eventRecurrenceIsOver(event *calendar.Event) bool {
-	if event.Recurrence == nil {
+// hasBrowser guesses whether a browser can be opened on this machine. SSH
+// sessions and Linux without a display are treated as headless, where butler
+// only prints the auth URL.
+func hasBrowser() bool {
+	if os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != "" {
 		return false
 	}
-	for _, recurrence := range event.Recurrence {
-		if strings.Contains(recurrence, "UNTIL") {
-			// recurrence looks like this: RRULE:FREQ=WEEKLY;UNTIL=20230823T215959Z;BYDAY=TH
-			// and we want to extract the date from the UNTIL part
-			until := strings.Split(recurrence, ";")[1]
-			untilDate := strings.Split(until, "=")[1]
-			untilTime, err := time.Parse("20060102T150405Z", untilDate)
-			if err != nil {
-				fmt.Println("Error parsing date:", err)
-				return false
-			}
-			if time.Now().After(untilTime) {
-				return true
-			}
-			return false
-		}
+	if runtime.GOOS == "linux" {
+		return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
 	}
-	return false
+	return true
 }
 
-func read_calendar(b []byte) {
-	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope, gmail.MailGoogleComScope)
+func getTokenFromDevice(config *oauth2.Config) (*oauth2.Token, error) {
+	if config.Endpoint.DeviceAuthURL == "" {
+		config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+	}
+
+	ctx := oauthContext()
+	response, err := config.DeviceAuth(ctx)
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		return nil, fmt.Errorf("unable to start device authorization: %w", oauthError(err))
 	}
-	client := getClient(config)
+	fmt.Printf("Visit %s and enter the code %s\n", response.VerificationURI, response.UserCode)
 
-	ctx := context.Background()
-	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	tok, err := config.DeviceAccessToken(ctx, response)
 	if err != nil {
-		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+		return nil, fmt.Errorf("unable to retrieve token from device authorization: %w", oauthError(err))
 	}
+	return tok, nil
+}
 
-	calendarId := "primary"
-	from := time.Now().Format(time.RFC3339)
-	yyyy, mm, dd := time.Now().Date()
-	tomorrow := time.Date(yyyy, mm, dd+1, 23, 59, 59, 0, time.Now().Location())
-	calendarEvents, err := srv.Events.List(calendarId).ShowDeleted(false).SingleEvents(true).TimeMin(from).TimeMax(tomorrow.Format(time.RFC3339)).Do()
+func getTokenFromWeb(config *oauth2.Config, redirect *url.URL) (*oauth2.Token, error) {
+	var listener net.Listener
+	if redirect != nil {
+		// Web clients only accept the redirect URIs registered for them.
+		addr := redirect.Host
+		if redirect.Port() == "" {
+			addr += ":80"
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to listen on %s for the auth callback registered in the credentials: %w", addr, err)
+		}
+		listener = l
+		config.RedirectURL = redirect.String()
+	} else {
+		addr := fmt.Sprintf("localhost:%d", authPort)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to listen on port %d for the auth callback, pick another one with -auth-port: %w", authPort, err)
+		}
+		listener = l
+		config.RedirectURL = "http://" + addr
+	}
+	// The state ties the callback to this login so other pages can't feed
+	// butler their own code, and PKCE keeps an intercepted code useless.
+	state, err := randomState()
 	if err != nil {
-		log.Fatalf("Unable to retrieve next ten of the user's events: %v", err)
+		return nil, fmt.Errorf("unable to generate OAuth state: %w", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+	// The URL is printed even when a browser opens, as the opener can
+	// succeed without showing anything.
+	fmt.Println("Visit this URL to authenticate:")
+	fmt.Println(authURL)
+	if !hasBrowser() {
+		port := listener.Addr().(*net.TCPAddr).Port
+		fmt.Printf("The browser must reach %s, over SSH forward the port first: ssh -L %d:localhost:%d <host>\n", config.RedirectURL, port, port)
+	} else if err := openBrowser(authURL); err != nil {
+		debugf("Unable to open a browser: %v", err)
 	}
 
-	events := []Event{}
+	var authCode, authError string
+	var accept sync.Once
+	shutdownChan := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	server := &http.Server{Handler: mux}
 
-	for _, item := range calendarEvents.Items {
-		startDate := ""
-		if item.Start != nil && item.Start.DateTime != "" {
-			startDate = item.Start.DateTime
-		} else if item.Start != nil && item.Start.Date != "" {
-			startDate = item.Start.Date
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("state")), []byte(state)) != 1 {
+			http.Error(w, "Invalid state, start the login from butler again.", http.StatusBadRequest)
+			return
 		}
-		endDateTime := ""
-		if item.End != nil && item.End.DateTime != "" {
-			endDateTime = item.End.DateTime
+		// Only the first valid callback counts.
+		accept.Do(func() {
+			authCode = r.URL.Query().Get("code")
+			authError = r.URL.Query().Get("error")
+			shutdownChan <- struct{}{}
+		})
+		if r.URL.Query().Get("error") != "" {
+			io.WriteString(w, "Authentication failed. You can close this tab.")
+			return
 		}
+		io.WriteString(w, "Authentication successful! You can close this tab.")
+	})
 
-		if startDate != "" {
-			isOver := eventRecurrenceIsOver(item)
-			if !isOver {
-				newEvent := Event{Summary: item.Summary, StartDate: startDate, StartTime: parseDate(startDate), EndDateTime: endDateTime}
-				events = append(events, newEvent)
-			}
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Printf("HTTP server Serve: %v", err)
 		}
-	}
+	}()
 
-	sortEvents(events)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-sigChan:
+	case <-shutdownChan:
+	}
 
-	if len(events) == 0 {
-		fmt.Println("No events found.")
-		return
+	if err := server.Shutdown(context.Background()); err != nil {
+		log.Printf("HTTP server Shutdown: %v", err)
 	}
 
-	todayName := time.Now().Format("Monday")
+	if authError == "access_denied" {
+		return nil, ErrAuthDenied
+	}
+	if authError != "" {
+		return nil, fmt.Errorf("authentication failed: %s", authError)
+	}
+	tok, err := config.Exchange(oauthContext(), authCode, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve token from web: %w", oauthError(err))
+	}
+	return tok, nil
+}
 
-	fmt.Println("")
-	for _, event := range events {
-		t, err := time.Parse(time.RFC3339, event.EndDateTime)
-		eventDay := event.StartTime.Local().Format("Monday")
-		if err != nil {
-			if eventDay == todayName {
-				fmt.Println("\033[1m***** ", strings.Replace(event.StartTime.Local().Format("Monday"), todayName, "Today", -1), "all day", " *****\033[0m")
-				fmt.Println("\033[1m", strings.TrimSpace(event.Summary), "\033[0m")
-				fmt.Println("")
-			} else {
-				fmt.Println("***** ", strings.Replace(event.StartTime.Local().Format("Monday"), todayName, "Today", -1), "all day", " *****")
-				fmt.Println(strings.TrimSpace(event.Summary))
-				fmt.Println("")
-			}
-		} else {
-			if eventDay == todayName {
-				fmt.Println("\033[1m***** ", strings.Replace(event.StartTime.Local().Format("Monday 15:04"), todayName, "Today", -1), "-", t.Local().Format("15:04"), " *****\033[0m")
-				fmt.Println("\033[1m", strings.TrimSpace(event.Summary), "\033[0m")
-				fmt.Println("")
-			} else {
-				fmt.Println("***** ", strings.Replace(event.StartTime.Local().Format("Monday 15:04"), todayName, "Today", -1), "-", t.Local().Format("15:04"), " *****")
-				fmt.Println(strings.TrimSpace(event.Summary))
-				fmt.Println("")
-			}
-		}
+// randomState returns an unguessable OAuth state value.
+func randomState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func handleMissingCredentials() bool {
-	fmt.Println("No credentials found. Please create a new project at https://console.cloud.google.com/apis/credentials and download the credentials.json file.")
-	fmt.Print("Press 'Enter' to save the credentials file ...")
-	bufio.NewReader(os.Stdin).ReadBytes('\n')
+// storedToken is the format of token.json. Scopes records what the token was
+// granted, files written before it was added got legacyScopes.
+type storedToken struct {
+	oauth2.Token
+	Scopes []string `json:"scopes,omitempty"`
+}
 
-	editor := os.Getenv("EDITOR")
-	if editor == "" {
-		fmt.Println("No $EDITOR environment variable set. Defaulting to 'vim'.")
-		editor = "vim"
-	}
+var legacyScopes = []string{gmail.MailGoogleComScope, calendar.CalendarReadonlyScope}
 
-	tmpFile, err := os.CreateTemp("", "example.*.json")
+func tokenFromFile(file string) (*oauth2.Token, []string, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		fmt.Printf("Failed to create temporary file: %s\n", err)
-		return false
+		return nil, nil, err
 	}
-	defer os.Remove(tmpFile.Name())
+	defer f.Close()
+	return decodeToken(f)
+}
 
-	cmd := exec.Command(editor, tmpFile.Name())
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// decodeToken reads a token.json, decrypting it with tokenPassphrase when
+// it is encrypted.
+func decodeToken(r io.Reader) (*oauth2.Token, []string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data, err = decryptToken(data, tokenPassphrase); err != nil {
+		return nil, nil, err
+	}
+	stored := &storedToken{}
+	err = json.Unmarshal(data, stored)
+	if stored.Scopes == nil {
+		stored.Scopes = legacyScopes
+	}
+	return &stored.Token, stored.Scopes, err
+}
 
-	err = cmd.Run()
+// saveToken writes token.json, encrypted when a passphrase is set. Tokens
+// saved in plaintext before are encrypted the next time they are saved.
+func saveToken(path string, token *oauth2.Token, scopes []string) {
+	infof("Saving credential file to: %s", path)
+	data, err := json.Marshal(storedToken{Token: *token, Scopes: scopes})
+	if err == nil && tokenPassphrase != "" {
+		data, err = encryptToken(data, tokenPassphrase)
+	}
 	if err != nil {
-		fmt.Printf("Failed to open editor: %s\n", err)
-		return false
+		log.Fatalf("Unable to encode oauth token: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		log.Fatalf("Unable to cache oauth token: %v", err)
 	}
+}
 
-	content, err := os.ReadFile(tmpFile.Name())
+// logout revokes the token of the active profile with Google and deletes it.
+// Tokens Google already considers invalid are deleted all the same.
+func logout() error {
+	path, err := getTokenPath()
 	if err != nil {
-		fmt.Printf("Failed to read temporary file: %s\n", err)
-		return false
+		return err
+	}
+	tok, _, err := tokenFromFile(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("Profile %s is not logged in.\n", profile)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read token: %w", err)
 	}
 
-	saveFilePath := getCredentialsPath()
-	err = os.WriteFile(saveFilePath, content, 0644)
+	token := tok.RefreshToken
+	if token == "" {
+		token = tok.AccessToken
+	}
+	resp, err := (&http.Client{Transport: apiTransport}).PostForm("https://oauth2.googleapis.com/revoke", url.Values{"token": {token}})
 	if err != nil {
-		fmt.Printf("Failed to write to file: %s\n", err)
-		return false
+		return fmt.Errorf("unable to revoke token: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest {
+		infof("The token was already invalid.")
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to revoke token: %s", resp.Status)
 	}
 
-	fmt.Printf("Content saved to %s\n", saveFilePath)
-	return true
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("unable to delete token: %w", err)
+	}
+	fmt.Printf("Logged out of profile %s.\n", profile)
+	return nil
 }
 
-func main() {
-	var mail = flag.Bool("mail", false, "show mail")
-	var calendar = flag.Bool("cal", false, "show calendar")
-	var numberOfMessages = flag.Int64("n", 100, "number of messages")
-	var labelsToSearch = flag.String("l", "UNREAD", "labels to search (case sensitive)")
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("Unable to encode JSON output: %v", err)
+	}
+}
 
-	flag.Parse()
+// mutate runs call, or with -dry-run only prints the change it would make.
+func mutate(description string, call func() error) error {
+	if dryRun {
+		fmt.Println("Dry run:", description)
+		return nil
+	}
+	return call()
+}
 
-	var b []byte
-	bt, err := os.ReadFile(getCredentialsPath())
-	if err != nil {
-		didSave := handleMissingCredentials()
-		if !didSave {
-			log.Fatalf("Unable to save client secret file: %v", err)
+var (
+	mailReadScopes      = []string{gmail.GmailReadonlyScope}
+	mailModifyScopes    = []string{gmail.GmailModifyScope}
+	mailSendScopes      = []string{gmail.GmailComposeScope}
+	calendarReadScopes  = []string{calendar.CalendarReadonlyScope}
+	calendarWriteScopes = []string{calendar.CalendarEventsScope}
+)
+
+// impliedBy lists the broader scopes that also grant a scope.
+var impliedBy = map[string][]string{
+	gmail.GmailReadonlyScope:       {gmail.GmailModifyScope, gmail.MailGoogleComScope},
+	gmail.GmailComposeScope:        {gmail.GmailModifyScope, gmail.MailGoogleComScope},
+	gmail.GmailModifyScope:         {gmail.MailGoogleComScope},
+	calendar.CalendarReadonlyScope: {calendar.CalendarScope},
+	calendar.CalendarEventsScope:   {calendar.CalendarScope},
+}
+
+func hasScopes(granted []string, required []string) bool {
+	has := map[string]bool{}
+	for _, scope := range granted {
+		has[scope] = true
+	}
+	for _, scope := range required {
+		if has[scope] {
+			continue
 		}
-		bt, err := os.ReadFile(getCredentialsPath())
-		if err != nil {
-			log.Fatalf("Unable to read client secret file: %v", err)
+		found := false
+		for _, broader := range impliedBy[scope] {
+			if has[broader] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
 		}
-		b = bt
 	}
-	b = bt
+	return true
+}
 
-	if *mail {
-		read_mail(b, numberOfMessages, labelsToSearch)
-	} else if *calendar {
-		read_calendar(b)
-	} else {
-		fmt.Println("please specify -mail or -cal")
+func unionScopes(a []string, b []string) []string {
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, scope := range append(append([]string{}, a...), b...) {
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
 
+// getAuthClient authenticates once per run. The client it returns is shared
+// by the Gmail and Calendar services, so scopes must be the union of what
+// the invocation needs from both.
+func getAuthClient(b []byte, scopes []string) (*http.Client, error) {
+	creds, err := parseCredentials(b)
+	if err == nil {
+		err = creds.check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid client secret file: %w", err)
+	}
+	if creds.Type == "service_account" {
+		return getServiceAccountClient(b, scopes)
+	}
+
+	config, err := google.ConfigFromJSON(b, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
+	}
+	var redirect *url.URL
+	if creds.Web != nil {
+		redirect, err = loopbackRedirect(creds.Web.RedirectURIs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return getClient(config, redirect)
+}
+
+// loopbackRedirect picks the first redirect URI butler can receive the auth
+// callback on, one on this machine.
+func loopbackRedirect(uris []string) (*url.URL, error) {
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme != "http" {
+			continue
+		}
+		if host := u.Hostname(); host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return u, nil
+		}
+	}
+	return nil, errors.New("web application credentials need an authorized redirect URI like http://localhost:3333, add one in the Google Cloud console or create a Desktop app OAuth client instead")
+}
+
+// readServiceAccountClient authenticates with the service account key at
+// path, for -service-account.
+func readServiceAccountClient(path string, scopes []string) (*http.Client, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account key: %w", err)
+	}
+	creds, err := parseCredentials(b)
+	if err == nil && creds.Type != "service_account" {
+		err = errors.New("this is not a service account key")
+	}
+	if err == nil {
+		err = creds.check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account key %s: %w", path, err)
+	}
+	return getServiceAccountClient(b, scopes)
+}
+
+// getServiceAccountClient acts as the -user mailbox through domain-wide
+// delegation. Without -user the service account can only use its own
+// calendars, it has no mailbox.
+func getServiceAccountClient(b []byte, scopes []string) (*http.Client, error) {
+	config, err := google.JWTConfigFromJSON(b, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse service account key: %w", err)
+	}
+	if mailbox != "me" {
+		config.Subject = mailbox
+	}
+	ctx := oauthContext()
+	return config.Client(ctx), nil
+}
+
+func getGmailService(client *http.Client) (*gmail.Service, error) {
+	ctx := context.Background()
+	srv, err := gmail.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Gmail client: %w", err)
+	}
+	return srv, nil
+}
+
+func listLabels(ctx context.Context, srv *gmail.Service) ([]Label, error) {
+	labels := []Label{}
+	resp, err := withRetry(ctx, srv.Users.Labels.List(mailbox).Context(ctx).Do)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve labels: %w", err)
+	}
+	for _, l := range resp.Labels {
+		label := Label{Id: l.Id, Name: l.Name}
+		if l.Color != nil {
+			label.TextColor, label.BackgroundColor = l.Color.TextColor, l.Color.BackgroundColor
+		}
+		labels = append(labels, label)
+	}
+	saveCachedLabels(labels)
+	return labels, nil
+}
+
+// cachedLabels returns the labels from the cache, or lists them when the
+// cache is stale or -refresh-labels is set.
+func cachedLabels(ctx context.Context, srv *gmail.Service) ([]Label, error) {
+	if !refreshLabels {
+		if labels, ok := loadCachedLabels(); ok {
+			return labels, nil
+		}
+	}
+	return listLabels(ctx, srv)
+}
+
+// resolveLabels looks up comma separated label names. Unknown names are an
+// error rather than silently searching without them.
+func resolveLabels(ctx context.Context, srv *gmail.Service, names string) ([]Label, error) {
+	labels, err := cachedLabels(ctx, srv)
+	if err != nil {
+		return nil, err
+	}
+	// A name missing from the cache may be a label created since.
+	for _, name := range strings.Split(names, ",") {
+		if _, err := findLabel(labels, name); name != "" && err != nil {
+			if labels, err = listLabels(ctx, srv); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	resolved := []Label{}
+	for _, name := range strings.Split(names, ",") {
+		if name == "" {
+			continue
+		}
+		label, err := selectLabel(labels, name)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, label)
+	}
+	return resolved, nil
+}
+
+// systemLabelSearches are the search operators for Gmail's system labels.
+var systemLabelSearches = map[string]string{
+	"INBOX":     "in:inbox",
+	"UNREAD":    "is:unread",
+	"STARRED":   "is:starred",
+	"IMPORTANT": "is:important",
+	"SENT":      "in:sent",
+	"DRAFT":     "in:drafts",
+	"SPAM":      "in:spam",
+	"TRASH":     "in:trash",
+	"CHAT":      "in:chats",
+}
+
+// labelSearch is the Gmail search term for a label. Search writes spaces
+// and slashes in label names as hyphens.
+func labelSearch(label Label) string {
+	if search, ok := systemLabelSearches[label.Id]; ok {
+		return search
+	}
+	if category, found := strings.CutPrefix(label.Id, "CATEGORY_"); found {
+		return "category:" + strings.ToLower(category)
+	}
+	return "label:" + strings.NewReplacer(" ", "-", "/", "-").Replace(label.Name)
+}
+
+// labelFilter returns the label IDs and search query to list messages
+// matching query with. Label IDs can only require every label, so with
+// AnyLabel the labels become a search for any of them instead.
+func labelFilter(ctx context.Context, srv *gmail.Service, query MessageQuery) ([]string, string, error) {
+	labels, err := resolveLabels(ctx, srv, query.Labels)
+	if err != nil {
+		return nil, "", err
+	}
+	if query.AnyLabel && len(labels) > 1 {
+		terms := []string{}
+		for _, l := range labels {
+			terms = append(terms, labelSearch(l))
+		}
+		return nil, strings.TrimSpace("{" + strings.Join(terms, " ") + "} " + query.Query), nil
+	}
+	ids := []string{}
+	for _, l := range labels {
+		ids = append(ids, l.Id)
+	}
+	return ids, query.Query, nil
+}
+
+func listMessages(ctx context.Context, srv *gmail.Service, query MessageQuery) ([]*gmail.Message, error) {
+	convertedLabelsToSearch, q, err := labelFilter(ctx, srv, query)
+	if err != nil {
+		return nil, err
+	}
+
+	user := mailbox
+
+	// A message can show up twice when mail arrives between pages, so only
+	// the first listing of each ID is kept.
+	messages := []*gmail.Message{}
+	seen := map[string]bool{}
+	pageToken := ""
+	for {
+		call := srv.Users.Messages.List(user).LabelIds(convertedLabelsToSearch...).MaxResults(query.Max - int64(len(messages)))
+		if q != "" {
+			call = call.Q(q)
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		r, err := withRetry(ctx, call.Context(ctx).Do)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve messages: %w", err)
+		}
+		for _, m := range r.Messages {
+			if !seen[m.Id] {
+				seen[m.Id] = true
+				messages = append(messages, m)
+			}
+		}
+		pageToken = r.NextPageToken
+
+		if !query.All || pageToken == "" || int64(len(messages)) >= query.Max {
+			break
+		}
+	}
+	if int64(len(messages)) > query.Max {
+		messages = messages[:query.Max]
+	}
+	return messages, nil
+}
+
+// countMessages counts every message matching query, ignoring its limit.
+// Only IDs are requested, so this is much cheaper than listing messages.
+func countMessages(ctx context.Context, client *http.Client, query MessageQuery) (int, error) {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return 0, err
+	}
+	labelIds, q, err := labelFilter(ctx, srv, query)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	pageToken := ""
+	for {
+		call := srv.Users.Messages.List(mailbox).LabelIds(labelIds...).MaxResults(500).Fields("messages/id", "nextPageToken")
+		if q != "" {
+			call = call.Q(q)
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		r, err := withRetry(ctx, call.Context(ctx).Do)
+		if err != nil {
+			return 0, fmt.Errorf("unable to retrieve messages: %w", err)
+		}
+		count += len(r.Messages)
+		pageToken = r.NextPageToken
+		if pageToken == "" {
+			return count, nil
+		}
+	}
+}
+
+func decodeBase64URL(data string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+}
+
+// findPart walks the MIME tree depth first and returns the first inline part
+// of the given type, which covers multipart/alternative nested in
+// multipart/mixed.
+func findPart(part *gmail.MessagePart, mimeType string) *gmail.MessagePart {
+	if part == nil {
+		return nil
+	}
+	if part.MimeType == mimeType && part.Filename == "" && part.Body != nil && part.Body.Data != "" {
+		return part
+	}
+	for _, p := range part.Parts {
+		if found := findPart(p, mimeType); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func messageBody(payload *gmail.MessagePart) string {
+	if part := findPart(payload, "text/plain"); part != nil {
+		data, err := decodeBase64URL(part.Body.Data)
+		if err == nil {
+			return string(data)
+		}
+		log.Printf("Unable to decode message body: %v", err)
+	}
+	if part := findPart(payload, "text/html"); part != nil {
+		data, err := decodeBase64URL(part.Body.Data)
+		if err == nil {
+			return htmlToText(string(data))
+		}
+		log.Printf("Unable to decode message body: %v", err)
+	}
+	return ""
+}
+
+// headerDecoder decodes RFC 2047 encoded-words in any charset the WHATWG
+// encoding standard knows about, not only UTF-8 and ISO-8859-1.
+var headerDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// decodeHeader returns value with encoded-words decoded, or unchanged when
+// they are malformed.
+func decodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		debugf("Unable to decode header %q: %v", value, err)
+		return value
+	}
+	return decoded
+}
+
+// parseHeaders returns the subject and sender of a message. The From header
+// is preferred, the domain of Return-Path is only used when From is missing.
+func parseHeaders(headers []*gmail.MessagePartHeader) (string, string) {
+	subject := ""
+	from := ""
+	returnPath := ""
+	for _, header := range headers {
+		switch header.Name {
+		case "Subject":
+			subject = decodeHeader(header.Value)
+		case "From":
+			from = decodeHeader(header.Value)
+		case "Return-Path":
+			returnPath = header.Value
+		}
+	}
+	if from == "" {
+		if _, domain, found := strings.Cut(returnPath, "@"); found {
+			from = strings.ReplaceAll(domain, ">", "")
+		}
+	}
+	return subject, from
+}
+
+// mailDateLayouts covers Date headers that net/mail rejects, such as ones
+// with a zone abbreviation in place of the numeric offset.
+var mailDateLayouts = []string{
+	"Mon, _2 Jan 2006 15:04:05 MST",
+	"_2 Jan 2006 15:04:05 MST",
+	"Mon, _2 Jan 2006 15:04 -0700",
+	"Mon, _2 Jan 2006 15:04:05 -0700 MST",
+	time.RFC850,
+	time.ANSIC,
+}
+
+// mailZoneOffsets are the zone abbreviations RFC 5322 still lets mailers
+// send, in seconds east of UTC.
+var mailZoneOffsets = map[string]int{
+	"EST": -5 * 3600, "EDT": -4 * 3600,
+	"CST": -6 * 3600, "CDT": -5 * 3600,
+	"MST": -7 * 3600, "MDT": -6 * 3600,
+	"PST": -8 * 3600, "PDT": -7 * 3600,
+}
+
+// fixZoneAbbreviation applies the offset of a known abbreviation, as
+// time.Parse treats abbreviations it can't resolve locally as UTC.
+func fixZoneAbbreviation(t time.Time) time.Time {
+	name, offset := t.Zone()
+	if fixed, ok := mailZoneOffsets[name]; ok && offset != fixed {
+		y, mo, d := t.Date()
+		return time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), 0, time.FixedZone(name, fixed))
+	}
+	return t
+}
+
+// messageDate parses the Date header, falling back to the time Gmail
+// received the message when the header is missing or malformed.
+func messageDate(headers []*gmail.MessagePartHeader, internalDate int64) time.Time {
+	for _, header := range headers {
+		if header.Name != "Date" {
+			continue
+		}
+		value := strings.TrimSpace(header.Value)
+		if t, err := mail.ParseDate(value); err == nil {
+			return fixZoneAbbreviation(t)
+		}
+		// Drop trailing comments like "(UTC)".
+		if i := strings.Index(value, " ("); i > 0 {
+			value = value[:i]
+		}
+		for _, layout := range mailDateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return fixZoneAbbreviation(t)
+			}
+		}
+	}
+	if internalDate > 0 {
+		return time.UnixMilli(internalDate)
+	}
+	return time.Time{}
+}
+
+// sortMessagesByDate puts the newest messages first.
+func sortMessagesByDate(messages []Message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Date.After(messages[j].Date)
+	})
+}
+
+// parseSender splits the From header into a display name and address. A
+// header net/mail can't parse, such as group syntax, is kept whole as the
+// name.
+func parseSender(headers []*gmail.MessagePartHeader) (string, string) {
+	for _, header := range headers {
+		if header.Name != "From" {
+			continue
+		}
+		parser := mail.AddressParser{WordDecoder: headerDecoder}
+		if address, err := parser.Parse(header.Value); err == nil {
+			return address.Name, address.Address
+		}
+		// Fall back to splitting "Name <address>" by hand, which covers
+		// names that only become invalid once decoded, like "Doe, Jane".
+		decoded := decodeHeader(header.Value)
+		if i := strings.LastIndex(decoded, "<"); i >= 0 && strings.HasSuffix(decoded, ">") && strings.Contains(decoded[i:], "@") {
+			return strings.Trim(strings.TrimSpace(decoded[:i]), `"`), decoded[i+1 : len(decoded)-1]
+		}
+		return decoded, ""
+	}
+	return "", ""
+}
+
+// displayName is how a sender is shown where space is short.
+func (m Message) displayName() string {
+	if m.SenderName != "" {
+		return m.SenderName
+	}
+	if m.SenderEmail != "" {
+		return m.SenderEmail
+	}
+	return m.Sender
+}
+
+// fetchMessage downloads the full message, or when headers isn't nil just
+// those headers and Gmail's short preview of the body.
+func fetchMessage(ctx context.Context, srv *gmail.Service, user string, id string, headers []string) (Message, error) {
+	call := srv.Users.Messages.Get(user, id).Format(messageFormat(headers))
+	if len(headers) > 0 {
+		call = call.MetadataHeaders(headers...)
+	}
+	msg, err := withRetry(ctx, call.Context(ctx).Do)
+	if err != nil {
+		return Message{}, err
+	}
+	return toMessage(msg), nil
+}
+
+// snippetHeaders are the headers requested when bodies aren't needed.
+var snippetHeaders = []string{"Subject", "From", "Return-Path", "Date"}
+
+// messageFormat is the Gmail format to request for headers: full messages
+// for nil, and "minimal" for no headers at all as "metadata" would return
+// every header then.
+func messageFormat(headers []string) string {
+	switch {
+	case headers == nil:
+		return "full"
+	case len(headers) == 0:
+		return "minimal"
+	}
+	return "metadata"
+}
+
+func toMessage(msg *gmail.Message) Message {
+	if msg.Payload == nil {
+		msg.Payload = &gmail.MessagePart{}
+	}
+	subject, from := parseHeaders(msg.Payload.Headers)
+	senderName, senderEmail := parseSender(msg.Payload.Headers)
+	returnPath := ""
+	for _, header := range msg.Payload.Headers {
+		if header.Name == "Return-Path" {
+			returnPath = strings.Trim(strings.TrimSpace(header.Value), "<>")
+		}
+	}
+	return Message{Id: msg.Id, ThreadId: msg.ThreadId, Labels: msg.LabelIds, Subject: subject, Sender: from, SenderName: senderName, SenderEmail: senderEmail, ReturnPath: returnPath, Body: messageBody(msg.Payload), Snippet: html.UnescapeString(msg.Snippet), Date: messageDate(msg.Payload.Headers, msg.InternalDate), Attachments: findAttachments(msg.Payload)}
+}
+
+// fetchMessages gets the listed messages using at most workers concurrent
+// requests. The result keeps the order of listed and skips messages that
+// could not be retrieved.
+func fetchMessages(ctx context.Context, srv *gmail.Service, user string, listed []*gmail.Message, workers int, headers []string, progress *progress) []Message {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]Message, len(listed))
+	errs := make([]error, len(listed))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, m := range listed {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if errs[i] = fetchLimiter.Wait(ctx); errs[i] == nil {
+				results[i], errs[i] = fetchMessage(ctx, srv, user, id, headers)
+			}
+			progress.add(1)
+		}(i, m.Id)
+	}
+	wg.Wait()
+
+	messages := []Message{}
+	for i, m := range listed {
+		if errs[i] != nil {
+			log.Printf("Unable to retrieve message %v: %v", m.Id, errs[i])
+			continue
+		}
+		messages = append(messages, results[i])
+	}
+	return messages
+}
+
+func readMail(ctx context.Context, client *http.Client, query MessageQuery, fetch FetchOptions) ([]Message, error) {
+	messages, _, err := readMailPage(ctx, client, query, fetch)
+	return messages, err
+}
+
+// readMailPage is readMail for one page of the listing when query has a
+// PerPage. The messages on the page are the only ones fetched in full.
+func readMailPage(ctx context.Context, client *http.Client, query MessageQuery, fetch FetchOptions) ([]Message, Page, error) {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	user := mailbox
+	var listed []*gmail.Message
+	var page Page
+	if query.PerPage > 0 {
+		listed, page, err = listMessagePage(ctx, client, srv, query, fetch.Workers)
+	} else {
+		listed, err = listMessages(ctx, srv, query)
+	}
+	if err != nil {
+		return nil, page, err
+	}
+	// Messages with only some of the headers would be incomplete in the cache.
+	if !fetch.UseCache || fetch.Snippet && fetch.Headers != nil {
+		return excludeSenders(batchFetchMessages(ctx, client, srv, user, listed, fetch.Workers, fetch.metadataHeaders()), query.ExcludeFrom), page, nil
+	}
+
+	full := !fetch.Snippet
+	cache := loadMessageCache()
+	missing := []*gmail.Message{}
+	cached := []*gmail.Message{}
+	for _, m := range listed {
+		if _, ok := cache.get(m.Id, full); ok {
+			cached = append(cached, m)
+		} else {
+			missing = append(missing, m)
+		}
+	}
+	labels := map[string][]string{}
+	for _, m := range batchFetchMessages(ctx, client, srv, user, missing, fetch.Workers, fetch.metadataHeaders()) {
+		labels[m.Id] = m.Labels
+		cache.put(m, full)
+	}
+	cache.save()
+	// Labels change all the time, so those of cached messages are fetched
+	// again, which is cheap without headers or body.
+	for _, m := range batchFetchMessages(ctx, client, srv, user, cached, fetch.Workers, []string{}) {
+		labels[m.Id] = m.Labels
+	}
+
+	messages := []Message{}
+	for _, m := range listed {
+		msgLabels, fetched := labels[m.Id]
+		if msg, ok := cache.get(m.Id, full); ok && fetched {
+			msg.Labels = msgLabels
+			messages = append(messages, msg)
+		}
+	}
+	return excludeSenders(messages, query.ExcludeFrom), page, nil
+}
+
+// threadURL links to a conversation in the Gmail web client.
+func threadURL(threadId string) string {
+	return "https://mail.google.com/mail/u/0/#all/" + threadId
+}
+
+// openMessage opens the conversation of a message in the browser.
+func openMessage(ctx context.Context, client *http.Client, id string) error {
+	if id == "" || strings.Contains(id, ",") {
+		return usageErrorf("-open requires a single message -id")
+	}
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	msg, err := withRetry(ctx, srv.Users.Messages.Get(mailbox, id).Format("minimal").Context(ctx).Do)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve message %v: %w", id, err)
+	}
+	url := threadURL(msg.ThreadId)
+	if err := openBrowser(url); err != nil {
+		fmt.Println("Open this link in your browser:", url)
+	}
+	return nil
+}
+
+// PrintOptions controls which parts of a message are printed.
+type PrintOptions struct {
+	Body    bool
+	Snippet bool
+	// MaxBodyBytes truncates longer bodies, 0 prints them in full.
+	MaxBodyBytes int
+	// Fields are the -fields to show in order, nil for the default ones.
+	Fields []string
+	// Labels names the label IDs of the labels field.
+	Labels map[string]Label
+}
+
+// truncateBody cuts body to at most limit bytes without splitting a UTF-8
+// sequence and notes how much was left out.
+func truncateBody(body string, limit int) string {
+	if limit <= 0 || len(body) <= limit {
+		return body
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return body[:cut] + fmt.Sprintf("\n… %d more bytes, use -max-body-bytes 0 to show everything", len(body)-cut)
+}
+
+// messageSummary counts messages, unread ones and the labels they have
+// besides UNREAD, like "12 messages (8 unread) across 3 labels".
+func messageSummary(messages []Message) string {
+	unread := 0
+	labels := map[string]bool{}
+	for _, m := range messages {
+		for _, id := range m.Labels {
+			if id == "UNREAD" {
+				unread++
+			} else {
+				labels[id] = true
+			}
+		}
+	}
+	count := func(n int, noun string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, noun)
+		}
+		return fmt.Sprintf("%d %ss", n, noun)
+	}
+	return fmt.Sprintf("%s (%d unread) across %s", count(len(messages), "message"), unread, count(len(labels), "label"))
+}
+
+// printMessageSummary prints the messageSummary footer below a listing,
+// unless -quiet is given or there was nothing to list.
+func printMessageSummary(messages []Message) {
+	if verbosity < levelInfo || len(messages) == 0 {
+		return
+	}
+	fmt.Println(dim(messageSummary(messages)))
+}
+
+func printMessages(messages []Message, options PrintOptions) {
+	writeMessages(os.Stdout, messages, options)
+}
+
+func writeMessages(w io.Writer, messages []Message, options PrintOptions) {
+	if len(messages) == 0 {
+		fmt.Fprintln(w, "No messages found.")
+		return
+	}
+
+	fields := options.Fields
+	if fields == nil {
+		fields = defaultFields
+		if options.Snippet {
+			fields = []string{"subject", "snippet", "sender", "date"}
+		}
+	}
+
+	fmt.Fprintln(w)
+	for _, m := range messages {
+		for _, field := range fields {
+			switch field {
+			case "subject":
+				fmt.Fprintln(w, bold("Subject: "+fitSubject(m.Subject, len("Subject: "))))
+			case "snippet":
+				fmt.Fprintln(w, strings.TrimSpace(m.Snippet))
+			case "sender":
+				if m.SenderName != "" && m.SenderEmail != "" {
+					fmt.Fprintln(w, "Sender:", m.SenderName, dim("<"+m.SenderEmail+">"))
+				} else {
+					fmt.Fprintln(w, "Sender:", m.displayName())
+				}
+			case "date":
+				if !m.Date.IsZero() {
+					fmt.Fprintln(w, "Date:", formatTime(m.Date, "Mon, 2 Jan 2006 15:04"))
+				}
+			case "labels":
+				names := []string{}
+				for _, id := range m.Labels {
+					names = append(names, colorLabel(shortLabelName(id, options.Labels), options.Labels[id]))
+				}
+				fmt.Fprintln(w, "Labels:", strings.Join(names, ", "))
+			}
+		}
+		if options.Body {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, truncateBody(strings.TrimSpace(m.Body), options.MaxBodyBytes))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// relativeTime describes t compactly relative to now, like "2h ago" or
+// "in 30m".
+func relativeTime(t time.Time, now time.Time) string {
+	delta := t.Sub(now)
+	future := delta > 0
+	if !future {
+		delta = -delta
+	}
+
+	var amount string
+	switch {
+	case delta < time.Minute:
+		return "now"
+	case delta < time.Hour:
+		amount = fmt.Sprintf("%dm", int(delta/time.Minute))
+	case delta < 24*time.Hour:
+		amount = fmt.Sprintf("%dh", int(delta/time.Hour))
+	case delta < 7*24*time.Hour:
+		amount = fmt.Sprintf("%dd", int(delta/(24*time.Hour)))
+	case delta < 365*24*time.Hour:
+		amount = fmt.Sprintf("%dw", int(delta/(7*24*time.Hour)))
+	default:
+		amount = fmt.Sprintf("%dy", int(delta/(365*24*time.Hour)))
+	}
+	if future {
+		return "in " + amount
+	}
+	return amount + " ago"
+}
+
+// formatTime shows t in layout, or relative to now with -relative.
+func formatTime(t time.Time, layout string) string {
+	if relativeTimes {
+		return relativeTime(t, time.Now())
+	}
+	return t.Local().Format(layout)
+}
+
+func parseDate(dateStr string) time.Time {
+	t, err := time.Parse(time.RFC3339, dateStr)
+	if err != nil {
+		// If it fails to parse as date-time, try parsing as date-only
+		t, err = time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			fmt.Println("Error parsing date:", err)
+			return time.Time{}
+		}
+	}
+	return t
+}
+
+// eventDay returns midnight in calendarZone of the day an event starts.
+// All-day events use their date as is, since their StartTime is midnight UTC.
+func eventDay(event Event) time.Time {
+	if event.AllDay {
+		if t, err := time.ParseInLocation("2006-01-02", event.StartDate, calendarZone); err == nil {
+			return t
+		}
+	}
+	yyyy, mm, dd := event.StartTime.In(calendarZone).Date()
+	return time.Date(yyyy, mm, dd, 0, 0, 0, 0, calendarZone)
+}
+
+// sortEvents orders events by day, with all-day events first within a day.
+func sortEvents(events []Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		di, dj := eventDay(events[i]), eventDay(events[j])
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		if events[i].AllDay != events[j].AllDay {
+			return events[i].AllDay
+		}
+		return events[i].StartTime.Before(events[j].StartTime)
+	})
+}
+
+func eventRecurrenceIsOver(event *calendar.Event) bool {
+	if event.Recurrence == nil {
+		return false
+	}
+	for _, recurrence := range event.Recurrence {
+		if strings.Contains(recurrence, "UNTIL") {
+			// recurrence looks like this: RRULE:FREQ=WEEKLY;UNTIL=20230823T215959Z;BYDAY=TH
+			// and we want to extract the date from the UNTIL part
+			until := strings.Split(recurrence, ";")[1]
+			untilDate := strings.Split(until, "=")[1]
+			untilTime, err := time.Parse("20060102T150405Z", untilDate)
+			if err != nil {
+				fmt.Println("Error parsing date:", err)
+				return false
+			}
+			if time.Now().After(untilTime) {
+				return true
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// parseDateFlag parses either a 2006-01-02 date or a duration relative to now
+// such as +7d, -2w or +12h.
+func parseDateFlag(value string, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		if len(value) < 3 {
+			return time.Time{}, usageErrorf("invalid relative date %q", value)
+		}
+		amount, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil {
+			return time.Time{}, usageErrorf("invalid relative date %q", value)
+		}
+		switch value[len(value)-1] {
+		case 'h':
+			return now.Add(time.Duration(amount) * time.Hour), nil
+		case 'd':
+			return now.AddDate(0, 0, amount), nil
+		case 'w':
+			return now.AddDate(0, 0, amount*7), nil
+		}
+		return time.Time{}, usageErrorf("invalid relative date %q, use h, d or w", value)
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, now.Location())
+	if err != nil {
+		return time.Time{}, usageErrorf("invalid date %q, use 2006-01-02 or a relative value like +7d", value)
+	}
+	return t, nil
+}
+
+// calendarWindow resolves the -since and -before flags. Without -before the
+// window ends at the end of the day after it starts, which defaults to now
+// through the end of tomorrow.
+func calendarWindow(since string, before string) (time.Time, time.Time, error) {
+	now := time.Now()
+	from := now
+	if since != "" {
+		t, err := parseDateFlag(since, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		from = t
+	}
+
+	yyyy, mm, dd := from.Date()
+	to := time.Date(yyyy, mm, dd+1, 23, 59, 59, 0, from.Location())
+	if before != "" {
+		t, err := parseDateFlag(before, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		to = t
+	}
+
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, usageErrorf("-before (%s) must be after -since (%s)", to.Format(time.RFC3339), from.Format(time.RFC3339))
+	}
+	return from, to, nil
+}
+
+func getCalendarService(client *http.Client) (*calendar.Service, error) {
+	ctx := context.Background()
+	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Calendar client: %w", err)
+	}
+	return srv, nil
+}
+
+func listCalendars(ctx context.Context, client *http.Client) error {
+	srv, err := getCalendarService(client)
+	if err != nil {
+		return err
+	}
+	list, err := withRetry(ctx, srv.CalendarList.List().Context(ctx).Do)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve calendars: %w", err)
+	}
+
+	fmt.Println("")
+	for _, c := range list.Items {
+		fmt.Println(bold(strings.TrimSpace(c.Summary)))
+		fmt.Println("Id:", c.Id)
+		fmt.Println("")
+	}
+	return nil
+}
+
+// resolveCalendarId maps a calendar summary to its ID. Values that don't
+// match any calendar in the user's list are passed through as IDs.
+func resolveCalendarId(ctx context.Context, srv *calendar.Service, name string) (string, error) {
+	if name == "primary" {
+		return name, nil
+	}
+	list, err := withRetry(ctx, srv.CalendarList.List().Context(ctx).Do)
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve calendars: %w", err)
+	}
+	for _, c := range list.Items {
+		if c.Id == name {
+			return c.Id, nil
+		}
+	}
+	for _, c := range list.Items {
+		if c.Summary == name || c.SummaryOverride == name {
+			return c.Id, nil
+		}
+	}
+	return name, nil
+}
+
+// eventDateTime turns a date-only value into an all-day event time and
+// anything else parseDate understands into a timed one.
+func eventDateTime(value string) (*calendar.EventDateTime, error) {
+	if _, err := time.Parse("2006-01-02", value); err == nil {
+		return &calendar.EventDateTime{Date: value}, nil
+	}
+	t := parseDate(value)
+	if t.IsZero() {
+		return nil, usageErrorf("invalid date %q, use 2006-01-02 or RFC3339", value)
+	}
+	return &calendar.EventDateTime{DateTime: t.Format(time.RFC3339)}, nil
+}
+
+// maxReminderMinutes is the furthest ahead the Calendar API allows a
+// reminder, four weeks.
+const maxReminderMinutes = 4 * 7 * 24 * 60
+
+// parseReminders turns -remind into event reminders: "default" for the
+// calendar's default reminders, or comma separated minutes before the event
+// for popup reminders. An empty value leaves the reminders to the API.
+func parseReminders(value string) (*calendar.EventReminders, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if value == "default" {
+		return &calendar.EventReminders{UseDefault: true}, nil
+	}
+	// UseDefault has to be sent as false for the overrides to apply.
+	reminders := &calendar.EventReminders{ForceSendFields: []string{"UseDefault"}}
+	for _, field := range strings.Split(value, ",") {
+		minutes, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil || minutes < 0 || minutes > maxReminderMinutes {
+			return nil, usageErrorf("invalid -remind %q, use minutes from 0 to %d or default", field, maxReminderMinutes)
+		}
+		reminders.Overrides = append(reminders.Overrides, &calendar.EventReminder{Method: "popup", Minutes: minutes, ForceSendFields: []string{"Minutes"}})
+	}
+	if len(reminders.Overrides) > 5 {
+		return nil, usageErrorf("-remind takes at most 5 reminders")
+	}
+	return reminders, nil
+}
+
+// formatReminders describes reminders for the confirmation of a new event.
+func formatReminders(reminders *calendar.EventReminders) string {
+	if reminders == nil || reminders.UseDefault {
+		return "calendar default"
+	}
+	described := []string{}
+	for _, r := range reminders.Overrides {
+		described = append(described, formatMinutes(r.Minutes)+" before")
+	}
+	return strings.Join(described, ", ")
+}
+
+func formatMinutes(minutes int64) string {
+	unit, n := "minute", minutes
+	switch {
+	case minutes >= 24*60 && minutes%(24*60) == 0:
+		unit, n = "day", minutes/(24*60)
+	case minutes >= 60 && minutes%60 == 0:
+		unit, n = "hour", minutes/60
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+func addEvent(ctx context.Context, client *http.Client, summary string, start string, end string, location string, remind string) error {
+	if summary == "" || start == "" {
+		return usageErrorf("-add-event requires -summary and -start")
+	}
+
+	startTime, err := eventDateTime(start)
+	if err != nil {
+		return err
+	}
+	if end == "" {
+		// Google treats the end date of all-day events as exclusive.
+		if startTime.Date != "" {
+			end = parseDate(start).AddDate(0, 0, 1).Format("2006-01-02")
+		} else {
+			end = parseDate(start).Add(time.Hour).Format(time.RFC3339)
+		}
+	}
+	endTime, err := eventDateTime(end)
+	if err != nil {
+		return err
+	}
+	if (startTime.Date == "") != (endTime.Date == "") {
+		return usageErrorf("-start and -end must both be dates or both be date-times")
+	}
+
+	reminders, err := parseReminders(remind)
+	if err != nil {
+		return err
+	}
+
+	srv, err := getCalendarService(client)
+	if err != nil {
+		return err
+	}
+	event := &calendar.Event{Summary: summary, Location: location, Start: startTime, End: endTime, Reminders: reminders}
+	description := fmt.Sprintf("create event %q from %s to %s with reminders %s", summary, start, end, formatReminders(reminders))
+	return mutate(description, func() error {
+		created, err := srv.Events.Insert("primary", event).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("unable to create event: %w", err)
+		}
+		fmt.Println("Event created:", created.HtmlLink)
+		fmt.Println("Reminders:", formatReminders(created.Reminders))
+		return nil
+	})
+}
+
+// readCalendar lists the events between from and to. A non-empty query is
+// matched by the API against the summary, description, location and
+// attendees of each event.
+func readCalendar(ctx context.Context, client *http.Client, calendarName string, from time.Time, to time.Time, query string) ([]Event, error) {
+	srv, err := getCalendarService(client)
+	if err != nil {
+		return nil, err
+	}
+	calendarId, err := resolveCalendarId(ctx, srv, calendarName)
+	if err != nil {
+		return nil, err
+	}
+	call := srv.Events.List(calendarId).ShowDeleted(false).SingleEvents(true).OrderBy("startTime").TimeMin(from.Format(time.RFC3339)).TimeMax(to.Format(time.RFC3339))
+	if query != "" {
+		call = call.Q(query)
+	}
+	// Long ranges or busy recurring events can exceed a single page.
+	items := []*calendar.Event{}
+	for {
+		calendarEvents, err := withRetry(ctx, call.Context(ctx).Do)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve the user's events: %w", err)
+		}
+		items = append(items, calendarEvents.Items...)
+		if calendarEvents.NextPageToken == "" {
+			break
+		}
+		call = call.PageToken(calendarEvents.NextPageToken)
+	}
+
+	events := []Event{}
+
+	for _, item := range items {
+		startDate := ""
+		allDay := false
+		if item.Start != nil && item.Start.DateTime != "" {
+			startDate = item.Start.DateTime
+		} else if item.Start != nil && item.Start.Date != "" {
+			startDate = item.Start.Date
+			allDay = true
+		}
+		endDateTime := ""
+		endDate := ""
+		if item.End != nil && item.End.DateTime != "" {
+			endDateTime = item.End.DateTime
+		} else if item.End != nil && item.End.Date != "" {
+			endDate = item.End.Date
+		}
+
+		if startDate != "" {
+			isOver := eventRecurrenceIsOver(item)
+			if !isOver {
+				newEvent := Event{Id: item.Id, Summary: item.Summary, StartDate: startDate, StartTime: parseDate(startDate), EndDateTime: endDateTime, EndDate: endDate, AllDay: allDay}
+				events = append(events, newEvent)
+			}
+		}
+	}
+
+	sortEvents(events)
+	return events, nil
+}
+
+func printEvents(events []Event) {
+	writeEvents(os.Stdout, events)
+}
+
+func writeEvents(w io.Writer, events []Event) {
+	if len(events) == 0 {
+		fmt.Fprintln(w, "No events found.")
+		return
+	}
+
+	yyyy, mm, dd := time.Now().In(calendarZone).Date()
+	today := time.Date(yyyy, mm, dd, 0, 0, 0, 0, calendarZone)
+	// Name the zone when it isn't the one the reader is used to.
+	dayLayout := "Monday, 2 January"
+	if calendarZone != time.Local {
+		dayLayout += " (MST)"
+	}
+
+	var day time.Time
+	for _, event := range events {
+		if d := eventDay(event); !d.Equal(day) {
+			day = d
+			header := "*****  " + day.Format(dayLayout) + "  *****"
+			if day.Equal(today) {
+				header = bold("*****  Today, " + day.Format(dayLayout) + "  *****")
+			}
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, header)
+		}
+
+		when := ""
+		if event.AllDay {
+			when = "all day"
+		} else if relativeTimes {
+			when = relativeTime(event.StartTime, time.Now())
+		} else if event.EndDateTime == "" {
+			when = event.StartTime.In(calendarZone).Format("15:04")
+		} else {
+			when = event.StartTime.In(calendarZone).Format("15:04") + " - " + parseDate(event.EndDateTime).In(calendarZone).Format("15:04")
+		}
+		line := fmt.Sprintf("  %-13s  %s", when, strings.TrimSpace(event.Summary))
+		if untilStart := time.Until(event.StartTime); !event.AllDay && untilStart >= 0 && untilStart < soonThreshold {
+			line = red(line)
+		}
+		if day.Equal(today) {
+			line = bold(line)
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w)
+}
+
+// readDashboard gets the rest of today's events and the messages matching
+// query with a single authenticated client.
+func readDashboard(ctx context.Context, client *http.Client, query MessageQuery, fetch FetchOptions) ([]Event, []Message, error) {
+	now := time.Now()
+	yyyy, mm, dd := now.Date()
+	endOfDay := time.Date(yyyy, mm, dd, 23, 59, 59, 0, now.Location())
+	events, err := readCalendar(ctx, client, "primary", now, endOfDay, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	messages, err := readMail(ctx, client, query, fetch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return events, messages, nil
+}
+
+func printDashboard(events []Event, messages []Message) {
+	writeDashboard(os.Stdout, events, messages)
+}
+
+func writeDashboard(w io.Writer, events []Event, messages []Message) {
+	fmt.Fprintln(w, bold("===== Today ====="))
+	writeEvents(w, events)
+	fmt.Fprintln(w, bold(fmt.Sprintf("===== Mail (%d) =====", len(messages))))
+	writeMessages(w, messages, PrintOptions{})
+}
+
+func readCredentialsFromStdin(reader *bufio.Reader) ([]byte, bool) {
+	fmt.Println("Paste the credentials JSON and finish with Ctrl-D:")
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		fmt.Printf("Failed to read credentials from stdin: %s\n", err)
+		return nil, false
+	}
+	if err := validateCredentials(content); err != nil {
+		fmt.Printf("That is not a valid OAuth client credentials file: %s\n", err)
+		return nil, false
+	}
+	return content, true
+}
+
+// readCredentialsFromEditor falls back to reading stdin when the editor
+// can't be started.
+func readCredentialsFromEditor(reader *bufio.Reader) ([]byte, bool) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		infof("No $EDITOR environment variable set. Defaulting to 'vim'.")
+		editor = "vim"
+	}
+
+	tmpFile, err := os.CreateTemp("", "example.*.json")
+	if err != nil {
+		fmt.Printf("Failed to create temporary file: %s\n", err)
+		return nil, false
+	}
+	defer os.Remove(tmpFile.Name())
+
+	for {
+		cmd := exec.Command(editor, tmpFile.Name())
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		err = cmd.Run()
+		if err != nil {
+			fmt.Printf("Failed to open editor: %s\n", err)
+			return readCredentialsFromStdin(reader)
+		}
+
+		content, err := os.ReadFile(tmpFile.Name())
+		if err != nil {
+			fmt.Printf("Failed to read temporary file: %s\n", err)
+			return nil, false
+		}
+
+		err = validateCredentials(content)
+		if err == nil {
+			return content, true
+		}
+		fmt.Printf("That is not a valid OAuth client credentials file: %s\n", err)
+		fmt.Print("Edit it again? [Y/n] ")
+		answer, err := reader.ReadString('\n')
+		if err != nil || strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "n") {
+			return nil, false
+		}
+	}
+}
+
+func handleMissingCredentials(fromStdin bool) bool {
+	fmt.Println("No credentials found. Please create a new project at https://console.cloud.google.com/apis/credentials and download the credentials.json file.")
+	reader := bufio.NewReader(os.Stdin)
+
+	var content []byte
+	var ok bool
+	if fromStdin {
+		content, ok = readCredentialsFromStdin(reader)
+	} else {
+		fmt.Print("Press 'Enter' to save the credentials file ...")
+		reader.ReadBytes('\n')
+		content, ok = readCredentialsFromEditor(reader)
+	}
+	if !ok {
+		return false
+	}
+
+	saveFilePath, err := getCredentialsPath()
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	err = os.WriteFile(saveFilePath, content, 0644)
+	if err != nil {
+		fmt.Printf("Failed to write to file: %s\n", err)
+		return false
+	}
+
+	infof("Content saved to %s", saveFilePath)
+	return true
+}
+
+// loadCredentials reads the OAuth client credentials, asking for them first
+// when there are none yet.
+func loadCredentials(fromStdin bool) ([]byte, error) {
+	b, err := readCredentials()
+	if !errors.Is(err, os.ErrNotExist) {
+		return b, err
+	}
+	if !handleMissingCredentials(fromStdin) {
+		return nil, fmt.Errorf("unable to save client secret file: %w", err)
+	}
+	b, err = readCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client secret file: %w", err)
+	}
+	return b, nil
+}
+
+func main() {
+	defaults := defaultConfig()
+
+	var mail = flag.Bool("mail", false, "show mail")
+	var calendar = flag.Bool("cal", false, "show calendar")
+	var numberOfMessages = flag.Int64("n", defaults.Messages, "number of messages")
+	var labelsToSearch = flag.String("l", defaults.Labels, "comma separated labels messages must all have, matched ignoring case unless -case-sensitive is set")
+	var excludeFrom = flag.String("exclude-from", "", "comma separated addresses and domains to hide messages from")
+	var anyLabel = flag.Bool("l-any", false, "match messages with any of the -l labels instead of all of them")
+	var searchQuery = flag.String("q", "", "gmail search query combined with -l, or with -cal free text matched against events")
+	var allPages = flag.Bool("all", false, "follow result pages until -n messages are collected")
+	var since = flag.String("since", "", "show events from this date (2006-01-02 or relative like +7d)")
+	var before = flag.String("before", "", "show events before this date (2006-01-02 or relative like +7d)")
+	var calendarName = flag.String("calendar", defaults.Calendar, "calendar id or name")
+	flag.DurationVar(&soonThreshold, "soon", 15*time.Minute, "highlight events starting within this long, 0 to turn it off")
+	var timeZone = flag.String("tz", "", "IANA time zone to show event times in, like America/New_York (default local time)")
+	var showCalendars = flag.Bool("list-calendars", false, "list available calendars")
+	var freeBusy = flag.Bool("freebusy", false, "show when the comma separated -calendar calendars are busy and free between -since and -before")
+	var newEvent = flag.Bool("add-event", false, "create a calendar event")
+	var summary = flag.String("summary", "", "summary of the new event")
+	var start = flag.String("start", "", "start of the new event (2006-01-02 or RFC3339)")
+	var end = flag.String("end", "", "end of the new event (2006-01-02 or RFC3339)")
+	var location = flag.String("location", "", "location of the new event")
+	var remind = flag.String("remind", "", "comma separated minutes before the new event to remind at, or default for the calendar's reminders")
+	var eventToDelete = flag.String("delete-event", "", "delete the event with this ID or summary, searched within -since and -before")
+	var series = flag.Bool("series", false, "with -delete-event, delete every occurrence of a recurring event")
+	var rsvp = flag.String("rsvp", "", "respond to the invitation to the event with this ID or summary, searched within -since and -before")
+	var response = flag.String("response", "", "response for -rsvp: accepted, declined or tentative")
+	var asJSON = flag.Bool("json", false, "print results as JSON")
+	var asTable = flag.Bool("table", false, "print messages as a table")
+	flag.IntVar(&outputWidth, "width", 0, "truncate subjects to fit this many columns, by default the terminal width")
+	var templateText = flag.String("template", "", "print every message or event with this Go text/template, like '{{.Subject}} <{{.Sender}}>'")
+	var sortBy = flag.String("sort", "", "sort messages by: date")
+	var ics = flag.Bool("ics", false, "export events as iCalendar")
+	var mbox = flag.Bool("mbox", false, "export messages in mbox format")
+	var out = flag.String("out", "", "write exported data to this file instead of stdout")
+	var markRead = flag.Bool("mark-read", false, "mark messages as read")
+	var archive = flag.Bool("archive", false, "archive messages")
+	var star = flag.Bool("star", false, "star messages")
+	var unstar = flag.Bool("unstar", false, "remove the star from messages")
+	var trash = flag.Bool("trash", false, "move messages to the trash")
+	var snooze = flag.String("snooze", "", "move the -id messages out of the inbox for a duration such as 3h or 2d, or until a date")
+	var unsnooze = flag.Bool("unsnooze-due", false, "return snoozed messages whose time has come to the inbox")
+	var yes = flag.Bool("yes", false, "don't ask for confirmation")
+	var ids = flag.String("id", "", "comma separated message ids")
+	var showBody = flag.Bool("body", false, "show message bodies")
+	var maxBodyBytes = flag.Int("max-body-bytes", 4096, "truncate longer message bodies, 0 to show them in full")
+	var showSnippet = flag.Bool("snippet", false, "only fetch headers and show a short preview of each message")
+	var fieldList = flag.String("fields", "", "comma separated fields to fetch and show: subject, sender, date, labels, snippet (default subject,sender,date)")
+	var showAttachments = flag.Bool("attachments", false, "list the attachments of messages")
+	var downloadDir = flag.String("download-attachments", "", "save the attachments of messages to this directory")
+	var noCache = flag.Bool("no-cache", false, "fetch every message instead of using the local cache")
+	var clearCache = flag.Bool("clear-cache", false, "delete the local message and label caches")
+	var openInBrowser = flag.Bool("open", false, "open the message given by -id in Gmail")
+	var tui = flag.Bool("tui", false, "browse messages in an interactive terminal UI")
+	var watch = flag.Bool("watch", false, "keep checking for new mail every -interval")
+	var interval = flag.Duration("interval", time.Minute, "time between checks with -watch")
+	var notifyNew = flag.Bool("notify", false, "show desktop notifications for new mail and upcoming events with -watch")
+	var notifyBefore = flag.Duration("notify-before", 10*time.Minute, "how long before an event starts to notify about it, 0 to only notify about mail")
+	var execCommand = flag.String("exec", "", "shell command to run for every message with -mail, or every new one with -watch")
+	var execTimeout = flag.Duration("exec-timeout", time.Minute, "give up on an -exec command after this long, 0 to wait forever")
+	var groupThreads = flag.Bool("threads", false, "group messages by conversation")
+	var searchTerm = flag.String("search", "", "search the cached messages offline, best matches first")
+	var online = flag.Bool("online", false, "run -search as a Gmail search instead of offline")
+	var importantOnly = flag.Bool("important-only", false, "only list messages Gmail marked as important")
+	var pageNumber = flag.Int("page", 0, "show this page of -mail or -cal results, of -per-page each")
+	var perPage = flag.Int("per-page", 0, "results per -page, 20 when only -page is given")
+	var countOnly = flag.Bool("count-only", false, "only print the number of matching messages")
+	var showCounts = flag.Bool("counts", false, "show message counts per label, limited to -l when given")
+	var createLabel = flag.String("create-label", "", "create a label with this name")
+	var deleteLabel = flag.String("delete-label", "", "delete the label with this name")
+	var renameLabel = flag.String("rename-label", "", "rename the label with this name to -new-name")
+	var newName = flag.String("new-name", "", "new name for -rename-label")
+	var send = flag.Bool("send", false, "send a message")
+	var to = flag.String("to", "", "comma separated recipients of the message to send")
+	var subject = flag.String("subject", "", "subject of the message to send")
+	var text = flag.String("text", "", "body of the message to send")
+	var bodyFile = flag.String("body-file", "", "read the body of the message to send from a file, - for stdin")
+	var showDrafts = flag.Bool("drafts", false, "list drafts")
+	var draftId = flag.String("send-draft", "", "send the draft with this id")
+	var reply = flag.Bool("reply", false, "reply to the message given with -id, written in $EDITOR unless -text or -body-file is set")
+	var digest = flag.Bool("digest", false, "summarize today's events and unread mail, for example from cron")
+	var emailTo = flag.String("email-to", "", "mail the -digest to these comma separated recipients instead of printing it")
+	var replyAll = flag.Bool("reply-all", false, "like -reply, also sending the reply to everyone the message went to")
+	var workers = flag.Int("workers", defaults.Workers, "number of messages to fetch concurrently")
+	var rate = flag.Float64("rate", defaults.Rate, "fetch at most this many messages per second, 0 for no limit")
+	var showProfiles = flag.Bool("list-profiles", false, "list profiles")
+	var logoutProfile = flag.Bool("logout", false, "revoke and delete the token of the active profile")
+	var tokenExport = flag.Bool("export-token", false, "print the token of the active profile, or write it to -out, to import it elsewhere")
+	var tokenImport = flag.String("import-token", "", "save the token in this file, or - for stdin, as the token of the active profile")
+	flag.IntVar(&authPort, "auth-port", 3333, "port for the OAuth callback server")
+	var timeout = flag.Duration("timeout", 30*time.Second, "give up on API requests after this long, 0 to wait forever")
+	flag.IntVar(&maxAttempts, "max-attempts", 5, "attempts per API request when rate limited or the server fails")
+	flag.BoolVar(&caseSensitiveLabels, "case-sensitive", false, "match label names only with the exact case")
+	flag.BoolVar(&refreshLabels, "refresh-labels", false, "list labels from Gmail instead of the label cache")
+	flag.BoolVar(&relativeTimes, "relative", false, "show times relative to now, like 2h ago")
+	flag.BoolVar(&dryRun, "dry-run", false, "print the changes that would be made without making them")
+	flag.BoolVar(&deviceAuth, "device-auth", false, "authenticate with a code on another device instead of a local browser")
+	flag.StringVar(&mailbox, "user", "me", "email address of the mailbox to use, for delegated or shared mailboxes")
+	flag.StringVar(&profile, "profile", "default", "profile to keep credentials and tokens under")
+	flag.StringVar(&credentialsFile, "credentials", "", "path to the OAuth client credentials file")
+	flag.StringVar(&tokenPassphrase, "passphrase", "", "encrypt the saved token with this passphrase, BUTLER_PASSPHRASE by default")
+	var serviceAccount = flag.String("service-account", "", "path to a service account key to act as the -user mailbox with, instead of logging in")
+	var caCert = flag.String("ca-cert", "", "also trust the CA certificates in this PEM file, for proxies that intercept TLS")
+	var insecure = flag.Bool("insecure", false, "don't verify TLS certificates, only for testing behind self-signed proxies")
+	var stdinCredentials = flag.Bool("stdin-credentials", false, "read missing credentials from stdin instead of an editor")
+	var showVersion = flag.Bool("version", false, "print version information")
+	var noColor = flag.Bool("no-color", defaults.NoColor, "disable colored output")
+	var quiet = flag.Bool("quiet", false, "only print results and errors")
+	var verbose = flag.Bool("verbose", false, "print diagnostics, including every API request")
+
+	flag.Parse()
+
+	if *quiet {
+		verbosity = levelQuiet
+	} else if *verbose {
+		verbosity = levelDebug
+	}
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	if profile == "" || profile == "." || profile == ".." || strings.ContainsAny(profile, `/\`) {
+		exitf(exitUsage, "Invalid profile name %q", profile)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		fatal(err)
+	}
+	given := givenFlags()
+	if !given["l"] {
+		*labelsToSearch = config.Labels
+	}
+	if !given["n"] {
+		*numberOfMessages = config.Messages
+	}
+	if !given["calendar"] {
+		*calendarName = config.Calendar
+	}
+	if !given["no-color"] {
+		*noColor = config.NoColor
+	}
+	if !given["workers"] {
+		*workers = config.Workers
+	}
+	if !given["rate"] {
+		*rate = config.Rate
+	}
+
+	initColor(*noColor)
+
+	showProgress = verbosity == levelInfo && !*asJSON && term.IsTerminal(int(os.Stdout.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
+
+	if *timeZone != "" {
+		if zone, err := time.LoadLocation(*timeZone); err != nil {
+			infof("Unable to load time zone %q, using local time: %v", *timeZone, err)
+		} else {
+			calendarZone = zone
+		}
+	}
+
+	if *asJSON && *asTable {
+		exitf(exitUsage, "-json and -table can't be used together")
+	}
+	if *sortBy != "" && *sortBy != "date" {
+		exitf(exitUsage, "Unknown -sort %q, only date is supported", *sortBy)
+	}
+	if outputWidth < 0 {
+		exitf(exitUsage, "Invalid -width %d, it can't be negative", outputWidth)
+	}
+	if *pageNumber < 0 || *perPage < 0 {
+		exitf(exitUsage, "-page and -per-page can't be negative")
+	}
+	if *pageNumber > 0 || *perPage > 0 {
+		*pageNumber = max(*pageNumber, 1)
+		if *perPage == 0 {
+			*perPage = 20
+		}
+	}
+	if *rate < 0 {
+		exitf(exitUsage, "Invalid -rate %v, it can't be negative", *rate)
+	}
+	fetchLimiter = newFetchLimiter(*rate)
+	if *templateText != "" && (*asJSON || *asTable) {
+		exitf(exitUsage, "-template can't be used with -json or -table")
+	}
+	var outputTemplate *template.Template
+	if *templateText != "" {
+		var err error
+		if outputTemplate, err = parseOutputTemplate(*templateText); err != nil {
+			fatal(err)
+		}
+	}
+	var fields []string
+	if *fieldList != "" {
+		var err error
+		if fields, err = parseFields(*fieldList); err != nil {
+			fatal(err)
+		}
+	}
+	if *execCommand != "" && !*mail && !*watch {
+		exitf(exitUsage, "-exec needs -mail or -watch")
+	}
+	if *star && *unstar {
+		exitf(exitUsage, "-star and -unstar can't be used together")
+	}
+	if *serviceAccount != "" && mailbox == "me" {
+		exitf(exitUsage, "-service-account needs -user, the mailbox to act as")
+	}
+	if *emailTo != "" && !*digest {
+		exitf(exitUsage, "-email-to needs -digest")
+	}
+
+	// Not the flag default, which -help would print.
+	if tokenPassphrase == "" {
+		tokenPassphrase = os.Getenv("BUTLER_PASSPHRASE")
+	}
+	if err := configureTransport(*caCert, *insecure); err != nil {
+		fatal(err)
+	}
+
+	if *showProfiles {
+		if err := listProfiles(); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *logoutProfile {
+		if err := logout(); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *tokenExport {
+		if err := exportToken(*out); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *tokenImport != "" {
+		if err := importToken(*tokenImport); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *clearCache {
+		if err := clearMessageCache(); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	query := MessageQuery{Labels: *labelsToSearch, AnyLabel: *anyLabel, Query: *searchQuery, Max: *numberOfMessages, All: *allPages, ExcludeFrom: parseExcludeFrom(*excludeFrom)}
+	if len(query.ExcludeFrom) > 0 {
+		query.Query = strings.TrimSpace(query.Query + " " + excludeSearch(query.ExcludeFrom))
+	}
+	if *importantOnly {
+		query.Query = strings.TrimSpace(query.Query + " is:important")
+	}
+	fetch := FetchOptions{Workers: *workers, UseCache: !*noCache, Snippet: *showSnippet}
+	if fields != nil {
+		// Only -body needs the full messages.
+		fetch.Snippet = !*showBody
+		fetch.Headers = fieldHeaders(fields)
+		if len(query.ExcludeFrom) > 0 {
+			// -exclude-from matches on the sender headers.
+			fetch.Headers = fieldHeaders(append([]string{"sender"}, fields...))
+		}
+	}
+	printOptions := PrintOptions{Body: *showBody, Snippet: *showSnippet, MaxBodyBytes: *maxBodyBytes, Fields: fields}
+	execOptions := ExecOptions{Command: *execCommand, Workers: *workers, Timeout: *execTimeout}
+
+	if *searchTerm != "" && !*online {
+		messages, ok := searchCachedMessages(*searchTerm, *numberOfMessages)
+		if ok {
+			if *asJSON {
+				printJSON(messages)
+			} else if outputTemplate != nil {
+				if err := printTemplate(outputTemplate, messages); err != nil {
+					fatal(err)
+				}
+			} else if *asTable {
+				printMessageTable(messages, nil)
+			} else {
+				printMessages(messages, printOptions)
+			}
+			if len(messages) == 0 {
+				os.Exit(exitNoResults)
+			}
+			return
+		}
+		infof("No cached messages to search, searching with Gmail instead")
+	}
+	if *searchTerm != "" {
+		// Online, -search is -mail with the term added to the Gmail search.
+		query.Query = strings.TrimSpace(query.Query + " " + *searchTerm)
+		*mail = true
+	}
+
+	// Service accounts have no credentials or token files to set up.
+	var b []byte
+	if *serviceAccount == "" {
+		var err error
+		if b, err = loadCredentials(*stdinCredentials); err != nil {
+			fatal(err)
+		}
+	}
+
+	var scopes []string
+	if *showCounts {
+		scopes = mailReadScopes
+	} else if *createLabel != "" || *deleteLabel != "" || *renameLabel != "" {
+		scopes = mailModifyScopes
+	} else if *send || *draftId != "" {
+		scopes = mailSendScopes
+	} else if *digest && *emailTo != "" {
+		scopes = unionScopes(unionScopes(mailReadScopes, calendarReadScopes), mailSendScopes)
+	} else if *reply || *replyAll {
+		// Replying reads the original, which sending alone can't.
+		scopes = unionScopes(mailReadScopes, mailSendScopes)
+	} else if *rsvp != "" || *eventToDelete != "" {
+		scopes = calendarWriteScopes
+		// Other calendars are looked up in the calendar list, which
+		// calendar.events doesn't grant.
+		if *calendarName != "primary" {
+			scopes = unionScopes(scopes, calendarReadScopes)
+		}
+	} else if *newEvent {
+		scopes = calendarWriteScopes
+	} else if *showCalendars || *freeBusy {
+		scopes = calendarReadScopes
+	} else if *markRead || *archive || *star || *unstar || *trash || *tui || *snooze != "" || *unsnooze {
+		scopes = mailModifyScopes
+	} else if *watch && *notifyNew && *notifyBefore > 0 {
+		scopes = unionScopes(mailReadScopes, calendarReadScopes)
+	} else if *mail || *mbox || *showDrafts || *countOnly || *watch || *openInBrowser || *showAttachments || *downloadDir != "" {
+		scopes = mailReadScopes
+	} else if *calendar {
+		scopes = calendarReadScopes
+	} else {
+		scopes = unionScopes(mailReadScopes, calendarReadScopes)
+	}
+
+	var client *http.Client
+	if *serviceAccount != "" {
+		client, err = readServiceAccountClient(*serviceAccount, scopes)
+	} else {
+		client, err = getAuthClient(b, scopes)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	// The first Ctrl-C aborts the requests in flight, a second one exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func(done <-chan struct{}) {
+		<-done
+		stop()
+	}(ctx.Done())
+	// -exec commands have their own timeout.
+	signalCtx := ctx
+	// -watch, -tui and exports that can take long apply the timeout to
+	// every request instead.
+	if *timeout > 0 && !*watch && !*tui && !*mbox && *downloadDir == "" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// Commands that read something exit with exitNoResults when nothing
+	// matched, so scripts can tell that apart from a failure.
+	found := true
+	if *showCounts {
+		// -l defaults to UNREAD, so only filter on labels given explicitly.
+		names := ""
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "l" {
+				names = f.Value.String()
+			}
+		})
+		counts, err := readLabelCounts(ctx, client, names)
+		if err != nil {
+			fatal(err)
+		}
+		if *asJSON {
+			printJSON(counts)
+		} else {
+			printLabelCounts(counts)
+		}
+	} else if *createLabel != "" || *deleteLabel != "" || *renameLabel != "" {
+		if err := manageLabels(ctx, client, *createLabel, *deleteLabel, *renameLabel, *newName); err != nil {
+			fatal(err)
+		}
+	} else if *send {
+		body, err := readBody(*text, *bodyFile)
+		if err != nil {
+			fatal(err)
+		}
+		if err := sendMail(ctx, client, *to, *subject, body); err != nil {
+			fatal(err)
+		}
+	} else if *draftId != "" {
+		if err := sendDraft(ctx, client, *draftId); err != nil {
+			fatal(err)
+		}
+	} else if *showDrafts {
+		drafts, err := readDrafts(ctx, client, *numberOfMessages)
+		if err != nil {
+			fatal(err)
+		}
+		found = len(drafts) > 0
+		if *asJSON {
+			printJSON(drafts)
+		} else {
+			printDrafts(drafts)
+		}
+	} else if *reply || *replyAll {
+		text, err := readBody(*text, *bodyFile)
+		if err != nil {
+			fatal(err)
+		}
+		if err := replyToMessage(ctx, client, *ids, *replyAll, text); err != nil {
+			fatal(err)
+		}
+	} else if *digest {
+		if err := sendDigest(ctx, client, query, fetch, *emailTo); err != nil {
+			fatal(err)
+		}
+	} else if *newEvent {
+		if err := addEvent(ctx, client, *summary, *start, *end, *location, *remind); err != nil {
+			fatal(err)
+		}
+	} else if *eventToDelete != "" {
+		from, to, err := calendarWindow(*since, *before)
+		if err != nil {
+			fatal(err)
+		}
+		if err := deleteEvent(ctx, client, *calendarName, *eventToDelete, from, to, *series, *yes); err != nil {
+			fatal(err)
+		}
+	} else if *rsvp != "" {
+		from, to, err := calendarWindow(*since, *before)
+		if err != nil {
+			fatal(err)
+		}
+		if err := rsvpEvent(ctx, client, *calendarName, *rsvp, *response, from, to); err != nil {
+			fatal(err)
+		}
+	} else if *showCalendars {
+		if err := listCalendars(ctx, client); err != nil {
+			fatal(err)
+		}
+	} else if *freeBusy {
+		from, to, err := calendarWindow(*since, *before)
+		if err != nil {
+			fatal(err)
+		}
+		calendars, err := readFreeBusy(ctx, client, *calendarName, from, to)
+		if err != nil {
+			fatal(err)
+		}
+		if *asJSON {
+			printJSON(calendars)
+		} else {
+			printFreeBusy(calendars)
+		}
+	} else if *markRead {
+		if err := markMessagesRead(ctx, client, *ids, query, *workers); err != nil {
+			fatal(err)
+		}
+	} else if *archive {
+		if err := archiveMessages(ctx, client, *ids, query, *workers); err != nil {
+			fatal(err)
+		}
+	} else if *star || *unstar {
+		if err := starMessages(ctx, client, *ids, query, *workers, *star); err != nil {
+			fatal(err)
+		}
+	} else if *snooze != "" {
+		if err := snoozeMessages(ctx, client, *ids, *snooze); err != nil {
+			fatal(err)
+		}
+	} else if *unsnooze {
+		if err := unsnoozeDue(ctx, client); err != nil {
+			fatal(err)
+		}
+	} else if *trash {
+		if err := trashMessages(ctx, client, *ids, query, *workers, *yes); err != nil {
+			fatal(err)
+		}
+	} else if *countOnly {
+		count, err := countMessages(ctx, client, query)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(count)
+		found = count > 0
+	} else if *openInBrowser {
+		if err := openMessage(ctx, client, *ids); err != nil {
+			fatal(err)
+		}
+	} else if *tui {
+		if err := runTUI(ctx, client, query, fetch, *timeout); err != nil {
+			fatal(err)
+		}
+	} else if *watch {
+		watchOptions := WatchOptions{Interval: *interval, Timeout: *timeout, Notify: *notifyNew, EventLead: *notifyBefore, Exec: execOptions, Print: PrintOptions{Snippet: *showSnippet, Fields: fields}}
+		if err := watchMail(ctx, client, query, fetch, watchOptions); err != nil {
+			fatal(err)
+		}
+	} else if *showAttachments || *downloadDir != "" {
+		// Attachments are only part of full messages.
+		fetch.Snippet = false
+		readCtx, cancel := requestContext(ctx, *timeout)
+		messages, err := readMail(readCtx, client, query, fetch)
+		cancel()
+		if err != nil {
+			fatal(err)
+		}
+		found = false
+		for _, m := range messages {
+			found = found || len(m.Attachments) > 0
+		}
+		if *downloadDir != "" {
+			if err := downloadAttachments(ctx, client, messages, *downloadDir, *timeout); err != nil {
+				fatal(err)
+			}
+		} else if *asJSON {
+			printJSON(messages)
+		} else {
+			printAttachments(messages)
+		}
+	} else if *mbox {
+		count, err := exportMbox(ctx, client, query, *workers, *out, *timeout)
+		if err != nil {
+			fatal(err)
+		}
+		found = count > 0
+	} else if *mail && *groupThreads {
+		threads, err := readThreads(ctx, client, query, *workers)
+		if err != nil {
+			fatal(err)
+		}
+		found = len(threads) > 0
+		if *asJSON {
+			printJSON(threads)
+		} else {
+			printThreads(threads)
+		}
+	} else if *mail {
+		query.Page, query.PerPage = *pageNumber, *perPage
+		messages, page, err := readMailPage(ctx, client, query, fetch)
+		if err != nil {
+			fatal(err)
+		}
+		found = len(messages) > 0
+		if *sortBy == "date" {
+			sortMessagesByDate(messages)
+		}
+		if *asJSON {
+			printJSON(messages)
+		} else if outputTemplate != nil {
+			if err := printTemplate(outputTemplate, messages); err != nil {
+				fatal(err)
+			}
+		} else if *asTable {
+			labels, err := readLabels(ctx, client)
+			if err != nil {
+				fatal(err)
+			}
+			printMessageTable(messages, labels)
+			printMessageSummary(messages)
+			printPageFooter(page)
+		} else {
+			if slices.Contains(fields, "labels") {
+				if printOptions.Labels, err = readLabels(ctx, client); err != nil {
+					fatal(err)
+				}
+			}
+			printMessages(messages, printOptions)
+			printMessageSummary(messages)
+			printPageFooter(page)
+		}
+		if *execCommand != "" {
+			runExec(signalCtx, messages, execOptions)
+		}
+	} else if *calendar {
+		from, to, err := calendarWindow(*since, *before)
+		if err != nil {
+			fatal(err)
+		}
+		events, err := readCalendar(ctx, client, *calendarName, from, to, *searchQuery)
+		if err != nil {
+			fatal(err)
+		}
+		events, page, err := paginate(events, *pageNumber, *perPage)
+		if err != nil {
+			fatal(err)
+		}
+		found = len(events) > 0
+		if *asJSON {
+			printJSON(events)
+		} else if outputTemplate != nil {
+			if err := printTemplate(outputTemplate, events); err != nil {
+				fatal(err)
+			}
+		} else if *ics {
+			if err := exportICS(events, *out); err != nil {
+				fatal(err)
+			}
+		} else {
+			printEvents(events)
+			printPageFooter(page)
+		}
+	} else {
+		events, messages, err := readDashboard(ctx, client, query, fetch)
+		if err != nil {
+			fatal(err)
+		}
+		found = len(events) > 0 || len(messages) > 0
+		if *asJSON {
+			printJSON(struct {
+				Events   []Event
+				Messages []Message
+			}{events, messages})
+		} else {
+			printDashboard(events, messages)
+		}
+	}
+	if !found {
+		os.Exit(exitNoResults)
 	}
 }
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeGmail returns a Gmail service that sends its requests to handler.
+func fakeGmail(t *testing.T, handler http.Handler) *gmail.Service {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	srv, err := gmail.NewService(context.Background(), option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := fakeGmail(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := listLabels(ctx, srv)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("listLabels against a slow server = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("listLabels took %s to time out", elapsed)
+	}
+}
+
+// fakeTokenEndpoint answers code exchanges with a token, checking that the
+// PKCE verifier matches the challenge sent with the auth URL in *challenge.
+func fakeTokenEndpoint(t *testing.T, challenge *string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "the-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// startWebLogin runs getTokenFromWeb on a free port and returns the auth URL
+// it prints along with where its result ends up.
+func startWebLogin(t *testing.T, config *oauth2.Config) (*url.URL, chan *oauth2.Token, chan error) {
+	t.Helper()
+	// Headless, so the URL is only printed.
+	t.Setenv("SSH_TTY", "/dev/pts/0")
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := authPort
+	t.Cleanup(func() { authPort = port })
+	authPort = l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	tokens, errs := make(chan *oauth2.Token, 1), make(chan error, 1)
+	go func() {
+		tok, err := getTokenFromWeb(config, nil)
+		tokens <- tok
+		errs <- err
+	}()
+
+	urls := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "https://") {
+				urls <- scanner.Text()
+			}
+		}
+	}()
+	var authURL *url.URL
+	select {
+	case u := <-urls:
+		authURL, err = url.Parse(u)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no auth URL printed")
+	}
+	os.Stdout = stdout
+	t.Cleanup(func() { w.Close() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authURL, tokens, errs
+}
+
+func callback(t *testing.T, config *oauth2.Config, query url.Values) int {
+	t.Helper()
+	resp, err := http.Get(config.RedirectURL + "/?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestGetTokenFromWebPKCEAndState(t *testing.T) {
+	var challenge string
+	server := fakeTokenEndpoint(t, &challenge)
+	config := &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth", TokenURL: server.URL}}
+
+	authURL, tokens, errs := startWebLogin(t, config)
+	query := authURL.Query()
+	challenge = query.Get("code_challenge")
+	if challenge == "" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("auth URL %s lacks an S256 code challenge", authURL)
+	}
+	if query.Get("state") == "" {
+		t.Fatalf("auth URL %s lacks a state", authURL)
+	}
+
+	if status := callback(t, config, url.Values{"state": {"forged"}, "code": {"forged-code"}}); status != http.StatusBadRequest {
+		t.Errorf("callback with a mismatched state got status %d, want %d", status, http.StatusBadRequest)
+	}
+	select {
+	case <-errs:
+		t.Fatal("login finished on a callback with a mismatched state")
+	default:
+	}
+
+	if status := callback(t, config, url.Values{"state": {query.Get("state")}, "code": {"the-code"}}); status != http.StatusOK {
+		t.Errorf("callback with the right state got status %d", status)
+	}
+	tok := <-tokens
+	if err := <-errs; err != nil {
+		t.Fatalf("getTokenFromWeb: %v", err)
+	}
+	if tok.RefreshToken != "refresh" {
+		t.Errorf("got refresh token %q, want %q", tok.RefreshToken, "refresh")
+	}
+}
+
+// Each login has its own callback handler, so logging in twice in one
+// process doesn't panic over a duplicate handler.
+func TestGetTokenFromWebTwice(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		var challenge string
+		server := fakeTokenEndpoint(t, &challenge)
+		config := &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth", TokenURL: server.URL}}
+		authURL, tokens, errs := startWebLogin(t, config)
+		challenge = authURL.Query().Get("code_challenge")
+		callback(t, config, url.Values{"state": {authURL.Query().Get("state")}, "code": {"the-code"}})
+		<-tokens
+		if err := <-errs; err != nil {
+			t.Fatalf("login %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestSavingTokenSourceSavesRefreshedToken(t *testing.T) {
+	defer func(passphrase string) { tokenPassphrase = passphrase }(tokenPassphrase)
+	tokenPassphrase = ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "refreshed", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer server.Close()
+	config := &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: server.URL}}
+
+	path := filepath.Join(t.TempDir(), "token.json")
+	expired := &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Now().Add(-time.Hour)}
+	scopes := []string{gmail.GmailReadonlyScope}
+	saveToken(path, expired, scopes)
+
+	source := &savingTokenSource{source: config.TokenSource(context.Background(), expired), path: path, scopes: scopes, last: expired}
+	if _, err := source.Token(); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	saved, granted, err := tokenFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != "refreshed" {
+		t.Errorf("saved access token %q, want %q", saved.AccessToken, "refreshed")
+	}
+	if saved.RefreshToken != "refresh" {
+		t.Errorf("saved refresh token %q, want it kept as %q", saved.RefreshToken, "refresh")
+	}
+	if len(granted) != 1 || granted[0] != gmail.GmailReadonlyScope {
+		t.Errorf("saved scopes %v, want %v", granted, scopes)
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "Quarterly report", "Quarterly report"},
+		{"Q encoding", "=?UTF-8?Q?Caf=C3=A9_ouvert?=", "Café ouvert"},
+		{"B encoding", "=?UTF-8?B?w4lxdWlwZQ==?=", "Équipe"},
+		{"Latin-1", "=?ISO-8859-1?Q?Gr=FC=DFe?=", "Grüße"},
+		{"Shift JIS", "=?Shift_JIS?B?g2WDWINn?=", "テスト"},
+		{"mixed with plain text", "Re: =?UTF-8?Q?r=C3=A9union?= demain", "Re: réunion demain"},
+		{"adjacent words", "=?UTF-8?Q?a?= =?UTF-8?Q?b?=", "ab"},
+		{"unknown charset", "=?x-unknown?Q?abc?=", "=?x-unknown?Q?abc?="},
+		{"malformed", "=?UTF-8?B?not base64!?=", "=?UTF-8?B?not base64!?="},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := decodeHeader(test.value); got != test.want {
+				t.Errorf("decodeHeader(%q) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildMessageQuotedPrintable(t *testing.T) {
+	body := "Café à 10h.\n" + strings.Repeat("long line ", 20) + "\n=end"
+	raw := buildMessage("me@example.com", []*mail.Address{{Address: "jane@example.com"}}, nil, "Réunion", nil, body)
+	for _, line := range strings.Split(raw, "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("line of %d characters: %q", len(line), line)
+		}
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Content-Transfer-Encoding"); got != "quoted-printable" {
+		t.Errorf("Content-Transfer-Encoding = %q, want quoted-printable", got)
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ReplaceAll(body, "\n", "\r\n"); string(decoded) != want {
+		t.Errorf("decoded body = %q, want %q", decoded, want)
+	}
+}
+
+func fromHeader(value string) []*gmail.MessagePartHeader {
+	return []*gmail.MessagePartHeader{{Name: "From", Value: value}}
+}
+
+func TestParseSender(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      string
+		wantName  string
+		wantEmail string
+	}{
+		{"quoted display name", `"Doe, Jane" <jane@example.com>`, "Doe, Jane", "jane@example.com"},
+		{"display name", "Jane Doe <jane@example.com>", "Jane Doe", "jane@example.com"},
+		{"encoded display name", "=?UTF-8?Q?Ren=C3=A9e?= <renee@example.com>", "Renée", "renee@example.com"},
+		{"encoded name with a comma", "=?UTF-8?Q?Doe,_Jane?= <jane@example.com>", "Doe, Jane", "jane@example.com"},
+		{"bare address", "jane@example.com", "", "jane@example.com"},
+		{"angle address", "<jane@example.com>", "", "jane@example.com"},
+		{"unparseable", "Mail Delivery System", "Mail Delivery System", ""},
+		{"empty", "", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, email := parseSender(fromHeader(test.from))
+			if name != test.wantName || email != test.wantEmail {
+				t.Errorf("parseSender(%q) = %q, %q, want %q, %q", test.from, name, email, test.wantName, test.wantEmail)
+			}
+		})
+	}
+}
+
+func TestParseHeadersOrder(t *testing.T) {
+	headers := []*gmail.MessagePartHeader{
+		{Name: "Return-Path", Value: "<bounce@mailer.example.com>"},
+		{Name: "Subject", Value: "=?UTF-8?Q?R=C3=A9union?="},
+		{Name: "From", Value: "Jane Doe <jane@example.com>"},
+		{Name: "To", Value: "me@example.com"},
+	}
+	// Every order of the headers gives the same result.
+	orders := [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {2, 0, 3, 1}, {1, 3, 0, 2}}
+	for _, order := range orders {
+		shuffled := []*gmail.MessagePartHeader{}
+		for _, i := range order {
+			shuffled = append(shuffled, headers[i])
+		}
+		subject, from := parseHeaders(shuffled)
+		if subject != "Réunion" || from != "Jane Doe <jane@example.com>" {
+			t.Errorf("parseHeaders in order %v = %q, %q", order, subject, from)
+		}
+	}
+
+	// Without From, the domain of Return-Path stands in wherever it is.
+	for _, headers := range [][]*gmail.MessagePartHeader{
+		{{Name: "Return-Path", Value: "<bounce@mailer.example.com>"}, {Name: "Subject", Value: "Hi"}},
+		{{Name: "Subject", Value: "Hi"}, {Name: "Return-Path", Value: "<bounce@mailer.example.com>"}},
+	} {
+		if _, from := parseHeaders(headers); from != "mailer.example.com" {
+			t.Errorf("parseHeaders without From = %q, want %q", from, "mailer.example.com")
+		}
+	}
+}
+
+func TestListMessagesDeduplicates(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	// A message with both labels turns up again on the second page.
+	pages := map[string]string{
+		"":      `{"messages": [{"id": "a"}, {"id": "both"}, {"id": "b"}], "nextPageToken": "page2"}`,
+		"page2": `{"messages": [{"id": "both"}, {"id": "c"}]}`,
+	}
+	var q string
+	srv := fakeGmail(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/users/me/labels"):
+			io.WriteString(w, `{"labels": [{"id": "Label_1", "name": "Receipts"}, {"id": "Label_2", "name": "Travel"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/users/me/messages"):
+			q = r.URL.Query().Get("q")
+			io.WriteString(w, pages[r.URL.Query().Get("pageToken")])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	listed, err := listMessages(context.Background(), srv, MessageQuery{Labels: "Receipts,Travel", AnyLabel: true, Max: 10, All: true})
+	if err != nil {
+		t.Fatalf("listMessages: %v", err)
+	}
+	got := []string{}
+	for _, m := range listed {
+		got = append(got, m.Id)
+	}
+	if want := "a both b c"; strings.Join(got, " ") != want {
+		t.Errorf("listMessages returned %v, want %s", got, want)
+	}
+	if !strings.Contains(q, "Receipts") || !strings.Contains(q, "Travel") {
+		t.Errorf("search %q doesn't match either label", q)
+	}
+}
+
+func TestLoadCredentialsMissingThenCreated(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BUTLER_CREDENTIALS", "")
+	t.Setenv("BUTLER_CREDENTIALS_B64", "")
+	defer func(name string) { profile = name }(profile)
+	profile = "default"
+	credentials := `{"installed": {"client_id": "id.apps.googleusercontent.com", "client_secret": "secret", "auth_uri": "https://accounts.google.com/o/oauth2/auth", "token_uri": "https://oauth2.googleapis.com/token", "redirect_uris": ["http://localhost"]}}`
+
+	if _, err := readCredentials(); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("readCredentials without a file = %v, want %v", err, ErrNoCredentials)
+	}
+
+	// The credentials are pasted on stdin.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, credentials)
+	w.Close()
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = r
+
+	b, err := loadCredentials(true)
+	if err != nil {
+		t.Fatalf("loadCredentials: %v", err)
+	}
+	if string(b) != credentials {
+		t.Errorf("loadCredentials = %s, want the pasted credentials", b)
+	}
+	// They were saved for the next run.
+	if b, err := readCredentials(); err != nil || string(b) != credentials {
+		t.Errorf("readCredentials after setup = %s, %v", b, err)
+	}
+}
+
+// redirectTransport sends every request to target instead of Google.
+type redirectTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+	return t.base.RoundTrip(r)
+}
+
+// fakeClient returns a client whose requests to Google APIs go to handler.
+func fakeClient(t *testing.T, handler http.Handler) *http.Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: redirectTransport{target, server.Client().Transport}}
+}
+
+func TestReadCalendarRecurringEvents(t *testing.T) {
+	defer func(zone *time.Location) { calendarZone = zone }(calendarZone)
+	calendarZone = time.UTC
+	// Two pages of a weekly standup expanded into instances, as the Calendar
+	// API returns them with singleEvents set.
+	pages := map[string]string{
+		"": `{"nextPageToken": "page2", "items": [
+			{"id": "standup_20300107", "recurringEventId": "standup", "summary": "Standup", "start": {"dateTime": "2030-01-07T09:30:00Z"}, "end": {"dateTime": "2030-01-07T09:45:00Z"}},
+			{"id": "standup_20300114", "recurringEventId": "standup", "summary": "Standup", "start": {"dateTime": "2030-01-14T09:30:00Z"}, "end": {"dateTime": "2030-01-14T09:45:00Z"}}
+		]}`,
+		"page2": `{"items": [
+			{"id": "offsite", "summary": "Offsite", "start": {"date": "2030-01-21"}, "end": {"date": "2030-01-22"}},
+			{"id": "standup_20300121", "recurringEventId": "standup", "summary": "Standup", "start": {"dateTime": "2030-01-21T09:30:00Z"}, "end": {"dateTime": "2030-01-21T09:45:00Z"}}
+		]}`,
+	}
+	client := fakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/calendar/v3/calendars/primary/events" || query.Get("singleEvents") != "true" || query.Get("orderBy") != "startTime" {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		page, ok := pages[query.Get("pageToken")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, page)
+	}))
+
+	from := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	events, err := readCalendar(context.Background(), client, "primary", from, from.AddDate(0, 1, 0), "")
+	if err != nil {
+		t.Fatalf("readCalendar: %v", err)
+	}
+	want := []string{"standup_20300107", "standup_20300114", "offsite", "standup_20300121"}
+	got := []string{}
+	for _, e := range events {
+		got = append(got, e.Id)
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("readCalendar returned %v, want %v", got, want)
+	}
+}
+
+func TestWriteEventsAllDay(t *testing.T) {
+	defer func(zone *time.Location, color bool) { calendarZone, useColor = zone, color }(calendarZone, useColor)
+	calendarZone, useColor = time.UTC, false
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"all day", Event{Summary: "Offsite", StartDate: "2030-01-21", EndDate: "2030-01-22", AllDay: true}, "  all day        Offsite\n"},
+		{"timed", Event{Summary: "Standup", StartDate: "2030-01-21T09:30:00Z", EndDateTime: "2030-01-21T09:45:00Z"}, "  09:30 - 09:45  Standup\n"},
+		{"timed without end", Event{Summary: "Call", StartDate: "2030-01-21T14:00:00Z"}, "  14:00          Call\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.event.StartTime = parseDate(test.event.StartDate)
+			var b strings.Builder
+			writeEvents(&b, []Event{test.event})
+			if !strings.Contains(b.String(), "Monday, 21 January") {
+				t.Errorf("writeEvents put the event under the wrong day:\n%s", b.String())
+			}
+			if !strings.Contains(b.String(), test.want) {
+				t.Errorf("writeEvents =\n%s\nwant a line %q", b.String(), test.want)
+			}
+		})
+	}
+}
+
+// The dashboard reads mail and events with one client, which logs in and
+// refreshes the token once for both.
+func TestReadDashboardSharesClient(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BUTLER_TOKEN_B64", "")
+	defer func(name string, passphrase string) { profile, tokenPassphrase = name, passphrase }(profile, tokenPassphrase)
+	profile, tokenPassphrase = "default", ""
+
+	var mu sync.Mutex
+	refreshes := 0
+	authorized := map[string]string{}
+	fake := fakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/token":
+			refreshes++
+			io.WriteString(w, `{"access_token": "refreshed", "token_type": "Bearer", "expires_in": 3600}`)
+		case r.URL.Path == "/gmail/v1/users/me/labels":
+			authorized["gmail"] = r.Header.Get("Authorization")
+			io.WriteString(w, `{"labels": [{"id": "INBOX", "name": "INBOX"}]}`)
+		case r.URL.Path == "/gmail/v1/users/me/messages":
+			io.WriteString(w, `{}`)
+		case r.URL.Path == "/calendar/v3/calendars/primary/events":
+			authorized["calendar"] = r.Header.Get("Authorization")
+			io.WriteString(w, `{"items": []}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer func(transport http.RoundTripper) { apiTransport = transport }(apiTransport)
+	apiTransport = fake.Transport
+
+	scopes := []string{gmail.GmailReadonlyScope, calendar.CalendarReadonlyScope}
+	path, err := getTokenPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveToken(path, &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Now().Add(-time.Hour)}, scopes)
+	credentials := `{"installed": {"client_id": "id.apps.googleusercontent.com", "client_secret": "secret", "auth_uri": "https://accounts.google.com/o/oauth2/auth", "token_uri": "https://oauth2.googleapis.com/token", "redirect_uris": ["http://localhost"]}}`
+
+	client, err := getAuthClient([]byte(credentials), scopes)
+	if err != nil {
+		t.Fatalf("getAuthClient: %v", err)
+	}
+	if _, _, err := readDashboard(context.Background(), client, MessageQuery{Labels: "INBOX", Max: 10}, FetchOptions{Workers: 1}); err != nil {
+		t.Fatalf("readDashboard: %v", err)
+	}
+	if refreshes != 1 {
+		t.Errorf("refreshed the token %d times, want once", refreshes)
+	}
+	for _, api := range []string{"gmail", "calendar"} {
+		if authorized[api] != "Bearer refreshed" {
+			t.Errorf("%s request authorized with %q, want the refreshed token", api, authorized[api])
+		}
+	}
+}
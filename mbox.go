@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// writeMboxMessage writes one raw RFC 822 message in mboxrd format: a From_
+// line with the sender and date, the message with line endings turned into
+// LF and lines starting with any number of ">" followed by "From " quoted
+// with one more ">", and a blank line.
+func writeMboxMessage(w *bufio.Writer, raw []byte, date time.Time) error {
+	sender := "MAILER-DAEMON"
+	if m, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		addresses := parseAddressHeader(m.Header.Get("Return-Path"))
+		if len(addresses) == 0 {
+			addresses = parseAddressHeader(m.Header.Get("From"))
+		}
+		if len(addresses) > 0 && addresses[0].Address != "" {
+			sender = addresses[0].Address
+		}
+	}
+	fmt.Fprintf(w, "From %s %s\n", sender, date.UTC().Format(time.ANSIC))
+
+	text := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			w.WriteString(">")
+		}
+		w.WriteString(line)
+	}
+	if !strings.HasSuffix(text, "\n") {
+		w.WriteString("\n")
+	}
+	_, err := w.WriteString("\n")
+	return err
+}
+
+// rawExcluded reports whether a raw message is from a sender in patterns, as
+// excludeSenders decides for fetched messages.
+func rawExcluded(raw []byte, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return false
+	}
+	msg := Message{}
+	if from := parseAddressHeader(m.Header.Get("From")); len(from) > 0 {
+		msg.SenderEmail = from[0].Address
+	}
+	if returnPath := parseAddressHeader(m.Header.Get("Return-Path")); len(returnPath) > 0 {
+		msg.ReturnPath = returnPath[0].Address
+	}
+	return len(excludeSenders([]Message{msg}, patterns)) == 0
+}
+
+// writeMbox fetches the listed messages in their raw form and writes them to
+// w as they arrive, workers at a time, so only a few messages are held in
+// memory however large the mailbox is. Each fetch is limited to timeout.
+// Messages from senders in exclude are left out.
+func writeMbox(ctx context.Context, srv *gmail.Service, listed []*gmail.Message, workers int, timeout time.Duration, exclude []string, w io.Writer) (int, error) {
+	workers = max(workers, 1)
+	progress := newProgress("Exporting messages", len(listed))
+	defer progress.finish()
+
+	bw := bufio.NewWriter(w)
+	written := 0
+	for start := 0; start < len(listed); start += workers {
+		chunk := listed[start:min(start+workers, len(listed))]
+		raws := make([]*gmail.Message, len(chunk))
+		errs := make([]error, len(chunk))
+		var wg sync.WaitGroup
+		for i, m := range chunk {
+			wg.Add(1)
+			go func(i int, id string) {
+				defer wg.Done()
+				if errs[i] = fetchLimiter.Wait(ctx); errs[i] == nil {
+					getCtx, cancel := requestContext(ctx, timeout)
+					raws[i], errs[i] = withRetry(getCtx, srv.Users.Messages.Get(mailbox, id).Format("raw").Context(getCtx).Do)
+					cancel()
+				}
+				progress.add(1)
+			}(i, m.Id)
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return written, ctx.Err()
+		}
+		for i, m := range chunk {
+			var raw []byte
+			err := errs[i]
+			if err == nil {
+				raw, err = decodeBase64URL(raws[i].Raw)
+			}
+			if err != nil {
+				log.Printf("Unable to retrieve message %v: %v", m.Id, err)
+				continue
+			}
+			if rawExcluded(raw, exclude) {
+				continue
+			}
+			if err := writeMboxMessage(bw, raw, time.UnixMilli(raws[i].InternalDate)); err != nil {
+				return written, err
+			}
+			written++
+		}
+	}
+	return written, bw.Flush()
+}
+
+// exportMbox writes the messages matching query to path, or to stdout when
+// path is empty, for mail clients such as mutt and Thunderbird to import.
+// Exports can take long, so timeout limits each request rather than the
+// whole export.
+func exportMbox(ctx context.Context, client *http.Client, query MessageQuery, workers int, path string, timeout time.Duration) (int, error) {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return 0, err
+	}
+	listCtx, cancel := requestContext(ctx, timeout)
+	listed, err := listMessages(listCtx, srv, query)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+
+	if path == "" {
+		return writeMbox(ctx, srv, listed, workers, timeout, query.ExcludeFrom, os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create %s: %w", path, err)
+	}
+	defer f.Close()
+	written, err := writeMbox(ctx, srv, listed, workers, timeout, query.ExcludeFrom, f)
+	if err != nil {
+		return written, fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	infof("Exported %d messages to %s", written, path)
+	return written, f.Close()
+}
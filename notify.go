@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// windowsToastScript shows a toast through the WinRT API. The title and
+// text are passed in the environment so they never need quoting.
+const windowsToastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$lines = $template.GetElementsByTagName('text')
+$lines.Item(0).AppendChild($template.CreateTextNode($env:BUTLER_NOTIFY_TITLE)) > $null
+$lines.Item(1).AppendChild($template.CreateTextNode($env:BUTLER_NOTIFY_BODY)) > $null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('butler').Show($toast)
+`
+
+// macNotificationScript shows a notification with AppleScript. The body and
+// title are passed as arguments so they never need quoting.
+const macNotificationScript = `
+on run argv
+	display notification (item 1 of argv) with title (item 2 of argv)
+end run
+`
+
+// notify shows a desktop notification with the platform's own tooling.
+func notify(title string, body string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", macNotificationScript, body, title)
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToastScript)
+		cmd.Env = append(os.Environ(), "BUTLER_NOTIFY_TITLE="+title, "BUTLER_NOTIFY_BODY="+body)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=butler", title, body)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to show notification: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Page is the part of a listing shown with -page and -per-page.
+type Page struct {
+	Number  int
+	PerPage int
+	// Total is the number of items on all pages, -1 when the listing
+	// stopped before the last page.
+	Total int
+	// More is whether there are pages after this one.
+	More bool
+}
+
+func (p Page) count() int {
+	return max((p.Total+p.PerPage-1)/p.PerPage, 1)
+}
+
+// paginate returns the items on page number of perPage items each. A
+// perPage of 0 returns all items.
+func paginate[T any](items []T, number int, perPage int) ([]T, Page, error) {
+	page := Page{Number: number, PerPage: perPage, Total: len(items)}
+	if perPage <= 0 {
+		return items, page, nil
+	}
+	if number > page.count() {
+		return nil, page, usageErrorf("there is no page %d, the %d results make %d pages", number, page.Total, page.count())
+	}
+	page.More = number < page.count()
+	start := (number - 1) * perPage
+	return items[start:min(start+perPage, len(items))], page, nil
+}
+
+// listMessagePage lists the messages on query.Page, following the page
+// tokens of the listing with query.PerPage messages each. Messages from
+// -exclude-from senders the search can't leave out, as they only match the
+// Return-Path, are dropped before counting, so pages stay full. Only the
+// headers needed for that are fetched of messages on earlier pages.
+func listMessagePage(ctx context.Context, client *http.Client, srv *gmail.Service, query MessageQuery, workers int) ([]*gmail.Message, Page, error) {
+	labelIds, q, err := labelFilter(ctx, srv, query)
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	page := Page{Number: query.Page, PerPage: query.PerPage, Total: -1}
+	start := (query.Page - 1) * query.PerPage
+	kept := []*gmail.Message{}
+	seen := map[string]bool{}
+	pageToken := ""
+	for {
+		call := srv.Users.Messages.List(mailbox).LabelIds(labelIds...).MaxResults(int64(query.PerPage)).Fields("messages/id", "messages/threadId", "nextPageToken")
+		if q != "" {
+			call = call.Q(q)
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		r, err := withRetry(ctx, call.Context(ctx).Do)
+		if err != nil {
+			return nil, page, fmt.Errorf("unable to retrieve messages: %w", err)
+		}
+		listed := []*gmail.Message{}
+		for _, m := range r.Messages {
+			if !seen[m.Id] {
+				seen[m.Id] = true
+				listed = append(listed, m)
+			}
+		}
+		if len(query.ExcludeFrom) > 0 {
+			listed = dropExcluded(ctx, client, srv, listed, query.ExcludeFrom, workers)
+		}
+		kept = append(kept, listed...)
+		pageToken = r.NextPageToken
+		if pageToken == "" || len(kept) >= start+query.PerPage {
+			break
+		}
+	}
+
+	if pageToken == "" {
+		page.Total = len(kept)
+	}
+	page.More = pageToken != "" || len(kept) > start+query.PerPage
+	if start >= len(kept) {
+		if query.Page == 1 {
+			return nil, page, nil
+		}
+		return nil, page, usageErrorf("there is no page %d, the %d results make %d pages", query.Page, page.Total, page.count())
+	}
+	return kept[start:min(start+query.PerPage, len(kept))], page, nil
+}
+
+// dropExcluded leaves out the listed messages from senders in patterns,
+// telling them apart by their From and Return-Path headers.
+func dropExcluded(ctx context.Context, client *http.Client, srv *gmail.Service, listed []*gmail.Message, patterns []string, workers int) []*gmail.Message {
+	included := map[string]bool{}
+	for _, m := range excludeSenders(batchFetchMessages(ctx, client, srv, mailbox, listed, workers, []string{"From", "Return-Path"}), patterns) {
+		included[m.Id] = true
+	}
+	kept := []*gmail.Message{}
+	for _, m := range listed {
+		if included[m.Id] {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// printPageFooter tells where a page is in the listing and how to get to the
+// next one. Like the summary it is left out with -quiet.
+func printPageFooter(page Page) {
+	if page.PerPage <= 0 || verbosity < levelInfo {
+		return
+	}
+	position := fmt.Sprintf("Page %d", page.Number)
+	if page.Total >= 0 {
+		position = fmt.Sprintf("Page %d/%d", page.Number, page.count())
+	}
+	if page.More {
+		fmt.Println(dim(fmt.Sprintf("%s, use -page %d for the next", position, page.Number+1)))
+	} else {
+		fmt.Println(dim(position))
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestListMessagePage(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	// Gmail's result pages of two messages each. m2 is sent on behalf of a
+	// domain that is excluded, which only its Return-Path shows.
+	pages := map[string]string{
+		"":   `{"messages": [{"id": "m1"}, {"id": "m2"}], "nextPageToken": "t2"}`,
+		"t2": `{"messages": [{"id": "m3"}, {"id": "m4"}], "nextPageToken": "t3"}`,
+		"t3": `{"messages": [{"id": "m5"}]}`,
+	}
+	client := fakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/gmail/v1/users/me/labels":
+			io.WriteString(w, `{"labels": [{"id": "INBOX", "name": "INBOX"}]}`)
+		case r.URL.Path == "/gmail/v1/users/me/messages":
+			if r.URL.Query().Get("maxResults") != "2" {
+				t.Errorf("listed %s, want pages of 2", r.URL)
+			}
+			io.WriteString(w, pages[r.URL.Query().Get("pageToken")])
+		case strings.HasPrefix(r.URL.Path, "/gmail/v1/users/me/messages/"):
+			id := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/messages/")
+			returnPath := "<" + id + "@example.com>"
+			if id == "m2" {
+				returnPath = "<bounce@mail.mailchimp.com>"
+			}
+			fmt.Fprintf(w, `{"id": %q, "payload": {"headers": [{"name": "From", "value": "%s@example.com"}, {"name": "Return-Path", "value": %q}]}}`, id, id, returnPath)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	srv, err := getGmailService(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		page    int
+		exclude string
+		want    string
+		total   int
+		more    bool
+	}{
+		{1, "", "m1 m2", -1, true},
+		{2, "", "m3 m4", -1, true},
+		{3, "", "m5", 5, false},
+		{2, "mailchimp.com", "m4 m5", 4, false},
+	}
+	for _, test := range tests {
+		query := MessageQuery{Page: test.page, PerPage: 2, ExcludeFrom: parseExcludeFrom(test.exclude)}
+		listed, page, err := listMessagePage(context.Background(), client, srv, query, 1)
+		if err != nil {
+			t.Fatalf("page %d excluding %q: %v", test.page, test.exclude, err)
+		}
+		got := []string{}
+		for _, m := range listed {
+			got = append(got, m.Id)
+		}
+		if strings.Join(got, " ") != test.want || page.Total != test.total || page.More != test.more {
+			t.Errorf("page %d excluding %q = %v, total %d, more %v, want %s, total %d, more %v", test.page, test.exclude, got, page.Total, page.More, test.want, test.total, test.more)
+		}
+	}
+
+	if _, _, err := listMessagePage(context.Background(), client, srv, MessageQuery{Page: 4, PerPage: 2}, 1); err == nil {
+		t.Error("listing a page past the end succeeded")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// showProgress enables progress on stderr, when both stdout and stderr are
+// terminals and no -quiet, -verbose or -json is given.
+var showProgress bool
+
+// progressDelay keeps quick fetches from flashing a progress line.
+const progressDelay = 300 * time.Millisecond
+
+// progress reports how many of total items are done on a single stderr line.
+// A nil progress reports nothing.
+type progress struct {
+	mu      sync.Mutex
+	label   string
+	total   int
+	done    int
+	start   time.Time
+	printed bool
+}
+
+func newProgress(label string, total int) *progress {
+	if !showProgress || total == 0 {
+		return nil
+	}
+	return &progress{label: label, total: total, start: time.Now()}
+}
+
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	if time.Since(p.start) < progressDelay {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\r%s %d/%d", p.label, p.done, p.total)
+	p.printed = true
+}
+
+// finish clears the progress line.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.printed {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
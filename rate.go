@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// fetchLimiter spaces out message fetches to stay under the per-user rate
+// limit, set with -rate. It lets everything through when fetches aren't
+// limited.
+var fetchLimiter = rate.NewLimiter(rate.Inf, 1)
+
+// newFetchLimiter lets perSecond fetches through evenly spaced, without
+// bursts, which is what keeps the concurrent fetcher from tripping quotas.
+// 0 means no limit.
+func newFetchLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
+
+// waitFetches blocks until n more messages may be fetched, such as those of
+// a batch request, or ctx is done.
+func waitFetches(ctx context.Context, n int) error {
+	for i := 0; i < n; i++ {
+		if err := fetchLimiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
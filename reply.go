@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"os/exec"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// replySubject adds "Re: " unless the subject already has it.
+func replySubject(subject string) string {
+	subject = strings.TrimSpace(subject)
+	if strings.HasPrefix(strings.ToLower(subject), "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// quoteBody prefixes every line of body with "> ".
+func quoteBody(body string) string {
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(body, "\r\n", "\n"), "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ">") {
+			lines[i] = ">" + line
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseAddressHeader parses an address list header, skipping it when it's
+// malformed rather than failing the reply.
+func parseAddressHeader(value string) []*mail.Address {
+	if value == "" {
+		return nil
+	}
+	addresses, err := (&mail.AddressParser{WordDecoder: headerDecoder}).ParseList(value)
+	if err != nil {
+		debugf("Unable to parse addresses %q: %v", value, err)
+		return nil
+	}
+	return addresses
+}
+
+// replyRecipients returns who a reply goes to: Reply-To or else the sender,
+// and with all set everyone else the original went to on Cc. The own
+// address is left out.
+func replyRecipients(headers map[string]string, self string, all bool) ([]*mail.Address, []*mail.Address) {
+	seen := map[string]bool{strings.ToLower(self): true}
+	add := func(list []*mail.Address, addresses []*mail.Address) []*mail.Address {
+		for _, a := range addresses {
+			if key := strings.ToLower(a.Address); !seen[key] {
+				seen[key] = true
+				list = append(list, a)
+			}
+		}
+		return list
+	}
+
+	primary := parseAddressHeader(headers["Reply-To"])
+	if len(primary) == 0 {
+		primary = parseAddressHeader(headers["From"])
+	}
+	to := add(nil, primary)
+	// Replying to a message sent by ourselves goes to its recipients.
+	if len(to) == 0 {
+		to = add(nil, parseAddressHeader(headers["To"]))
+	}
+	var cc []*mail.Address
+	if all {
+		cc = add(cc, parseAddressHeader(headers["To"]))
+		cc = add(cc, parseAddressHeader(headers["Cc"]))
+	}
+	return to, cc
+}
+
+// editText opens initial in $EDITOR and returns the saved text.
+func editText(initial string) (string, error) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		infof("No $EDITOR environment variable set. Defaulting to 'vim'.")
+		editor = "vim"
+	}
+
+	tmpFile, err := os.CreateTemp("", "butler-reply.*.txt")
+	if err != nil {
+		return "", fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.WriteString(initial)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to write temporary file: %w", err)
+	}
+
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], tmpFile.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("unable to run editor: %w", err)
+	}
+	content, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		return "", fmt.Errorf("unable to read temporary file: %w", err)
+	}
+	return string(content), nil
+}
+
+// replyToMessage sends a reply in the thread of message id, quoting it. The
+// response is text when given, otherwise it's written in $EDITOR.
+func replyToMessage(ctx context.Context, client *http.Client, id string, all bool, text string) error {
+	if id == "" || strings.Contains(id, ",") {
+		return usageErrorf("-reply requires a single message -id")
+	}
+
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	original, err := withRetry(ctx, srv.Users.Messages.Get(mailbox, id).Format("full").Context(ctx).Do)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve message %s: %w", id, err)
+	}
+	profile, err := withRetry(ctx, srv.Users.GetProfile(mailbox).Context(ctx).Do)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the sender address: %w", err)
+	}
+
+	headers := map[string]string{}
+	if original.Payload != nil {
+		for _, h := range original.Payload.Headers {
+			// Header names are case-insensitive, Gmail keeps them as sent.
+			headers[http.CanonicalHeaderKey(h.Name)] = h.Value
+		}
+	}
+	to, cc := replyRecipients(headers, profile.EmailAddress, all)
+	if len(to) == 0 {
+		return errors.New("the message has no address to reply to")
+	}
+
+	message := toMessage(original)
+	quoted := fmt.Sprintf("On %s, %s wrote:\n%s\n", message.Date.Format("Mon, 2 Jan 2006 at 15:04"), message.displayName(), quoteBody(message.Body))
+	var body string
+	if text != "" {
+		body = strings.TrimRight(text, "\n") + "\n\n" + quoted
+	} else {
+		template := "\n\n" + quoted
+		body, err = editText(template)
+		if err != nil {
+			return err
+		}
+		if body == template || strings.TrimSpace(body) == "" {
+			return errors.New("the reply was left empty, not sending it")
+		}
+	}
+
+	// References lists the thread so far, so clients thread the reply.
+	replyHeaders := []string{}
+	if messageId := headers["Message-Id"]; messageId != "" {
+		references := strings.TrimSpace(headers["References"] + " " + messageId)
+		replyHeaders = append(replyHeaders, "In-Reply-To: "+messageId, "References: "+references)
+	}
+	subject := replySubject(decodeHeader(headers["Subject"]))
+	raw := buildMessage(profile.EmailAddress, to, cc, subject, replyHeaders, body)
+	reply := &gmail.Message{Raw: base64.URLEncoding.EncodeToString([]byte(raw)), ThreadId: original.ThreadId}
+
+	addresses := []string{}
+	for _, r := range append(to, cc...) {
+		addresses = append(addresses, r.Address)
+	}
+	description := fmt.Sprintf("send reply %q to %s", subject, strings.Join(addresses, ", "))
+	return mutate(description, func() error {
+		sent, err := srv.Users.Messages.Send(mailbox, reply).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("unable to send reply: %w", err)
+		}
+		fmt.Println("Reply sent:", sent.Id)
+		return nil
+	})
+}
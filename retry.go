@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+var maxAttempts = 5
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// withRetry runs call until it succeeds, fails with an error that isn't worth
+// retrying, maxAttempts is reached or ctx is done. Only use it for idempotent
+// calls.
+func withRetry[T any](ctx context.Context, call func(...googleapi.CallOption) (T, error)) (T, error) {
+	var result T
+	var err error
+	for attempt := 0; ; attempt++ {
+		result, err = call()
+		if err == nil || !retryable(err) || attempt+1 >= maxAttempts {
+			return result, err
+		}
+		delay := retryDelay(err, attempt)
+		debugf("Retrying in %s after: %v", delay.Round(time.Millisecond), err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			// Interrupted, rather than failed with the last error.
+			timer.Stop()
+			return result, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func retryable(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// retryDelay honors Retry-After when the server sends it and otherwise backs
+// off exponentially with jitter.
+func retryDelay(err error, attempt int) time.Duration {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Header != nil {
+		if after := apiErr.Header.Get("Retry-After"); after != "" {
+			if seconds, err := strconv.Atoi(after); err == nil {
+				return time.Duration(seconds) * time.Second
+			}
+			if t, err := http.ParseTime(after); err == nil {
+				return time.Until(t)
+			}
+		}
+	}
+
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// requestContext limits a single request of a long running command to
+// timeout, where -timeout can't apply to the whole run. 0 means no limit.
+func requestContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestWithRetryCancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	_, err := withRetry(ctx, func(...googleapi.CallOption) (int, error) {
+		calls++
+		return 0, &googleapi.Error{Code: http.StatusServiceUnavailable}
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("withRetry cancelled during backoff = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if calls != 1 {
+		t.Errorf("withRetry made %d calls, want 1", calls)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+var rsvpResponses = []string{"accepted", "declined", "tentative"}
+
+// findEvent gets the event with ID nameOrId, or else the one between from
+// and to whose summary is nameOrId.
+func findEvent(ctx context.Context, client *http.Client, srv *calendar.Service, calendarName string, calendarId string, nameOrId string, from time.Time, to time.Time) (*calendar.Event, error) {
+	event, err := withRetry(ctx, srv.Events.Get(calendarId, nameOrId).Context(ctx).Do)
+	var apiErr *googleapi.Error
+	if err == nil || !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound && apiErr.Code != http.StatusBadRequest {
+		return event, err
+	}
+
+	events, err := readCalendar(ctx, client, calendarName, from, to, nameOrId)
+	if err != nil {
+		return nil, err
+	}
+	matches := []Event{}
+	for _, e := range events {
+		if strings.EqualFold(strings.TrimSpace(e.Summary), nameOrId) {
+			matches = append(matches, e)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, usageErrorf("no event with ID or summary %q between %s and %s", nameOrId, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	case 1:
+		return withRetry(ctx, srv.Events.Get(calendarId, matches[0].Id).Context(ctx).Do)
+	}
+	ids := []string{}
+	for _, e := range matches {
+		ids = append(ids, e.Id+" ("+e.StartTime.In(calendarZone).Format("2 Jan 15:04")+")")
+	}
+	return nil, usageErrorf("%d events named %q, pick one by ID: %s", len(matches), nameOrId, strings.Join(ids, ", "))
+}
+
+// rsvpEvent sets the authenticated user's response to an invitation.
+func rsvpEvent(ctx context.Context, client *http.Client, calendarName string, nameOrId string, response string, from time.Time, to time.Time) error {
+	valid := false
+	for _, r := range rsvpResponses {
+		valid = valid || r == response
+	}
+	if !valid {
+		return usageErrorf("-rsvp requires -response with one of %s", strings.Join(rsvpResponses, ", "))
+	}
+
+	srv, err := getCalendarService(client)
+	if err != nil {
+		return err
+	}
+	calendarId, err := resolveCalendarId(ctx, srv, calendarName)
+	if err != nil {
+		return err
+	}
+	event, err := findEvent(ctx, client, srv, calendarName, calendarId, nameOrId, from, to)
+	if err != nil {
+		return fmt.Errorf("unable to find event: %w", err)
+	}
+
+	// Patching replaces the whole attendee list, so send it back with only
+	// our own response changed.
+	attendees := event.Attendees
+	self := -1
+	for i, a := range attendees {
+		if a.Self {
+			self = i
+		}
+	}
+	if self < 0 {
+		return fmt.Errorf("you aren't an attendee of %q", event.Summary)
+	}
+	attendees[self].ResponseStatus = response
+
+	description := fmt.Sprintf("respond %s to %q", response, event.Summary)
+	return mutate(description, func() error {
+		updated, err := withRetry(ctx, srv.Events.Patch(calendarId, event.Id, &calendar.Event{Attendees: attendees}).Context(ctx).Do)
+		if err != nil {
+			return fmt.Errorf("unable to respond to event: %w", err)
+		}
+		for _, a := range updated.Attendees {
+			if a.Self {
+				fmt.Printf("%s: %s\n", strings.TrimSpace(updated.Summary), a.ResponseStatus)
+			}
+		}
+		return nil
+	})
+}
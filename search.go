@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// searchField is a part of a message -search looks at, with how much a
+// match in it counts.
+type searchField struct {
+	text   string
+	weight float64
+}
+
+// matchScore rates how well word matches text: a substring counts most,
+// more so at the start of a word, and the letters of word appearing in order
+// counts less the further they are spread out. 0 means no match.
+func matchScore(text string, word string) float64 {
+	if i := strings.Index(text, word); i >= 0 {
+		if before, _ := utf8.DecodeLastRuneInString(text[:i]); i == 0 || !unicode.IsLetter(before) && !unicode.IsDigit(before) {
+			return 1.5
+		}
+		return 1
+	}
+	// Short words would match nearly everything as a subsequence.
+	if len([]rune(word)) < 3 {
+		return 0
+	}
+	wordRunes := []rune(word)
+	matched, first, last := 0, -1, -1
+	for i, r := range []rune(text) {
+		if r == wordRunes[matched] {
+			if first < 0 {
+				first = i
+			}
+			last = i
+			matched++
+			if matched == len(wordRunes) {
+				break
+			}
+		}
+	}
+	if matched < len(wordRunes) {
+		return 0
+	}
+	return 0.5 * float64(len(wordRunes)) / float64(last-first+1)
+}
+
+// messageScore rates message against every word of the search, which must
+// all match somewhere.
+func messageScore(m Message, words []string) float64 {
+	fields := []searchField{
+		{strings.ToLower(m.Subject), 3},
+		{strings.ToLower(m.SenderName + " " + m.SenderEmail + " " + m.Sender), 2},
+		{strings.ToLower(m.Snippet), 1},
+		{strings.ToLower(m.Body), 1},
+	}
+	total := 0.0
+	for _, word := range words {
+		best := 0.0
+		for _, f := range fields {
+			best = max(best, matchScore(f.text, word)*f.weight)
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total
+}
+
+// searchCachedMessages searches the subjects, senders and bodies of the
+// cached messages of the mailbox in use without going online, best matches
+// first. The messages have no labels, which aren't cached. ok is false when
+// there is no cache to search.
+func searchCachedMessages(term string, limit int64) (messages []Message, ok bool) {
+	cache := loadMessageCache()
+	words := strings.Fields(strings.ToLower(term))
+	scores := map[string]float64{}
+	messages = []Message{}
+	for _, entry := range cache.entries {
+		if entry.Mailbox != mailbox {
+			continue
+		}
+		ok = true
+		if score := messageScore(entry.Message, words); score > 0 {
+			scores[entry.Message.Id] = score
+			messages = append(messages, entry.Message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if scores[messages[i].Id] != scores[messages[j].Id] {
+			return scores[messages[i].Id] > scores[messages[j].Id]
+		}
+		return messages[i].Date.After(messages[j].Date)
+	})
+	if limit > 0 && int64(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+	return messages, ok
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// readBody returns text, or the contents of path when it is set. A path of
+// "-" reads from stdin.
+func readBody(text string, path string) (string, error) {
+	if path == "" {
+		return text, nil
+	}
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read message body: %w", err)
+	}
+	return string(data), nil
+}
+
+func formatAddresses(addresses []*mail.Address) string {
+	formatted := []string{}
+	for _, addr := range addresses {
+		formatted = append(formatted, addr.String())
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// buildMessage formats a plain text RFC 2822 message. headers are extra
+// "Name: value" lines, such as In-Reply-To for replies. The body is quoted
+// printable, so non-ASCII text and long lines survive any mail server.
+func buildMessage(from string, to []*mail.Address, cc []*mail.Address, subject string, headers []string, body string) string {
+	var msg strings.Builder
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + formatAddresses(to) + "\r\n")
+	if len(cc) > 0 {
+		msg.WriteString("Cc: " + formatAddresses(cc) + "\r\n")
+	}
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	for _, header := range headers {
+		msg.WriteString(header + "\r\n")
+	}
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"UTF-8\"\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	msg.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(body))
+	qp.Close()
+	return msg.String()
+}
+
+func sendMail(ctx context.Context, client *http.Client, to string, subject string, body string) error {
+	if to == "" {
+		return usageErrorf("-send requires -to")
+	}
+	recipients, err := mail.ParseAddressList(to)
+	if err != nil {
+		return usageErrorf("invalid recipients %q: %v", to, err)
+	}
+
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	profile, err := withRetry(ctx, srv.Users.GetProfile(mailbox).Context(ctx).Do)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the sender address: %w", err)
+	}
+
+	raw := buildMessage(profile.EmailAddress, recipients, nil, subject, nil, body)
+	message := &gmail.Message{Raw: base64.URLEncoding.EncodeToString([]byte(raw))}
+	addresses := []string{}
+	for _, r := range recipients {
+		addresses = append(addresses, r.Address)
+	}
+	description := fmt.Sprintf("send message %q to %s", subject, strings.Join(addresses, ", "))
+	return mutate(description, func() error {
+		sent, err := srv.Users.Messages.Send(mailbox, message).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("unable to send message: %w", err)
+		}
+		fmt.Println("Message sent:", sent.Id)
+		return nil
+	})
+}
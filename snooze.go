@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// snoozeLabelName is the label snoozed messages wait under, out of the inbox.
+const snoozeLabelName = "Snoozed"
+
+// snoozeEntry is when a snoozed message returns to the inbox of Mailbox.
+type snoozeEntry struct {
+	Mailbox string
+	Until   time.Time
+}
+
+// getSnoozePath returns where snoozed messages are kept, with the profile's
+// credentials rather than in the cache directory, which is safe to delete.
+// A file left in the cache directory by earlier versions is moved there.
+func getSnoozePath() (string, error) {
+	profileDir, err := getProfileDir()
+	if err != nil {
+		return "", err
+	}
+	path := profileDir + "/snoozed.json"
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if profileCacheDir, err := getProfileCacheDir(); err == nil {
+			if err := os.Rename(profileCacheDir+"/snoozed.json", path); err == nil {
+				debugf("Moved snoozed messages to %s", path)
+			}
+		}
+	}
+	return path, nil
+}
+
+// loadSnoozed reads the snoozed messages keyed by message ID. Losing them
+// would leave messages snoozed forever, so an unreadable file is an error.
+func loadSnoozed() (map[string]snoozeEntry, error) {
+	entries := map[string]snoozeEntry{}
+	path, err := getSnoozePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read snoozed messages: %w", err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("unable to parse snoozed messages %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+func saveSnoozed(entries map[string]snoozeEntry) error {
+	if dryRun {
+		return nil
+	}
+	path, err := getSnoozePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("unable to encode snoozed messages: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("unable to write snoozed messages: %w", err)
+	}
+	return nil
+}
+
+// parseSnooze returns when a message snoozed for value wakes up. value is a
+// duration such as 90m or 2d, or a 2006-01-02 date.
+func parseSnooze(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, usageErrorf("-snooze must be in the future")
+		}
+		return now.Add(d), nil
+	}
+	if value != "" && !strings.HasPrefix(value, "+") && strings.ContainsAny(value[len(value)-1:], "dw") {
+		value = "+" + value
+	}
+	until, err := parseDateFlag(value, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !until.After(now) {
+		return time.Time{}, usageErrorf("-snooze must be in the future")
+	}
+	return until, nil
+}
+
+// snoozeLabel returns the Snoozed label, creating it the first time.
+func snoozeLabel(ctx context.Context, srv *gmail.Service) (Label, error) {
+	labels, err := listLabels(ctx, srv)
+	if err != nil {
+		return Label{}, err
+	}
+	for _, l := range labels {
+		if l.Name == snoozeLabelName {
+			return l, nil
+		}
+	}
+	label := Label{Name: snoozeLabelName}
+	err = mutate(fmt.Sprintf("create label %q", snoozeLabelName), func() error {
+		created, err := srv.Users.Labels.Create(mailbox, &gmail.Label{Name: snoozeLabelName}).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("unable to create label %q: %w", snoozeLabelName, err)
+		}
+		label.Id = created.Id
+		return nil
+	})
+	return label, err
+}
+
+// snoozeMessages moves the messages out of the inbox under the Snoozed label
+// and records when unsnoozeDue should bring them back.
+func snoozeMessages(ctx context.Context, client *http.Client, ids string, duration string) error {
+	if ids == "" {
+		return usageErrorf("-snooze requires -id")
+	}
+	until, err := parseSnooze(duration, time.Now())
+	if err != nil {
+		return err
+	}
+	entries, err := loadSnoozed()
+	if err != nil {
+		return err
+	}
+
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	label, err := snoozeLabel(ctx, srv)
+	if err != nil {
+		return err
+	}
+	messageIds, err := selectMessageIds(ctx, client, srv, ids, MessageQuery{}, 1)
+	if err != nil {
+		return err
+	}
+
+	move := changeLabels(ctx, srv, []string{label.Id}, []string{"INBOX"})
+	done := "Snoozed until " + until.Format("Mon 2 Jan 15:04")
+	err = modifyMessages(messageIds, "snooze", done, func(id string) error {
+		if err := move(id); err != nil {
+			return err
+		}
+		entries[id] = snoozeEntry{Mailbox: mailbox, Until: until}
+		return nil
+	})
+	if saveErr := saveSnoozed(entries); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// unsnoozeDue returns the snoozed messages whose time has come to the inbox.
+// It is meant to run regularly, for example from cron.
+func unsnoozeDue(ctx context.Context, client *http.Client) error {
+	entries, err := loadSnoozed()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	due := []string{}
+	for id, entry := range entries {
+		if entry.Mailbox == mailbox && !entry.Until.After(now) {
+			due = append(due, id)
+		}
+	}
+	if len(due) == 0 {
+		debugf("No snoozed messages are due")
+		return nil
+	}
+
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	label, err := snoozeLabel(ctx, srv)
+	if err != nil {
+		return err
+	}
+
+	move := changeLabels(ctx, srv, []string{"INBOX"}, []string{label.Id})
+	err = modifyMessages(due, "unsnooze", "Back in the inbox", func(id string) error {
+		err := move(id)
+		// A message deleted in the meantime can't come back.
+		var apiErr *googleapi.Error
+		if err == nil || errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			delete(entries, id)
+		}
+		return err
+	})
+	if saveErr := saveSnoozed(entries); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
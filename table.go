@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+const (
+	defaultTableWidth = 120
+	tableDateLayout   = "2006-01-02 15:04"
+)
+
+// systemLabelColors stand in for the colors Gmail doesn't let users set on
+// system labels, as background and text.
+var systemLabelColors = map[string][2]string{
+	"IMPORTANT": {"#ffad47", "#000000"},
+	"STARRED":   {"#fad165", "#000000"},
+}
+
+// readLabels maps label IDs to labels so tables can show user labels by
+// name rather than as Label_123, in their Gmail colors. Labels come from the
+// label cache while it is fresh.
+func readLabels(ctx context.Context, client *http.Client) (map[string]Label, error) {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return nil, err
+	}
+	list, err := cachedLabels(ctx, srv)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]Label{}
+	for _, l := range list {
+		if colors, ok := systemLabelColors[l.Id]; ok && l.BackgroundColor == "" {
+			l.BackgroundColor, l.TextColor = colors[0], colors[1]
+		}
+		labels[l.Id] = l
+	}
+	return labels, nil
+}
+
+func shortLabelName(id string, labels map[string]Label) string {
+	name := id
+	if label, ok := labels[id]; ok {
+		name = label.Name
+	}
+	if strings.HasPrefix(name, "CATEGORY_") {
+		return strings.ToLower(strings.TrimPrefix(name, "CATEGORY_"))
+	}
+	return name
+}
+
+func truncate(s string, width int) string {
+	if width < 1 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// ansi256 maps a #rrggbb color to the closest entry of the 6x6x6 color cube
+// in the 256 color palette.
+func ansi256(hex string) (int, bool) {
+	var r, g, b int
+	if _, err := fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return 0, false
+	}
+	level := func(c int) int { return (c*5 + 127) / 255 }
+	return 16 + 36*level(r) + 6*level(g) + level(b), true
+}
+
+func colorLabel(name string, label Label) string {
+	if !useColor {
+		return name
+	}
+	codes := []string{}
+	if c, ok := ansi256(label.TextColor); ok {
+		codes = append(codes, fmt.Sprintf("38;5;%d", c))
+	}
+	if c, ok := ansi256(label.BackgroundColor); ok {
+		codes = append(codes, fmt.Sprintf("48;5;%d", c))
+	}
+	if len(codes) == 0 {
+		return name
+	}
+	return "\033[" + strings.Join(codes, ";") + "m" + name + "\033[0m"
+}
+
+// formatLabels lists short label names within width, colored like in Gmail.
+// It must only be used for the last column of a table, as tabwriter counts
+// the escape sequences towards the width of a cell.
+func formatLabels(ids []string, labels map[string]Label, width int) string {
+	names := []string{}
+	for _, id := range ids {
+		names = append(names, shortLabelName(id, labels))
+	}
+	plain := strings.Join(names, ",")
+	if truncated := truncate(plain, width); truncated != plain {
+		return truncated
+	}
+	colored := []string{}
+	for i, id := range ids {
+		colored = append(colored, colorLabel(names[i], labels[id]))
+	}
+	return strings.Join(colored, ",")
+}
+
+// outputWidth is the width subjects are fitted to, set with -width. Zero
+// uses the width of the terminal.
+var outputWidth int
+
+// displayWidth returns the width to fit output to, or 0 when stdout isn't a
+// terminal and no -width is given, as piped output shouldn't be cut short.
+func displayWidth() int {
+	if outputWidth > 0 {
+		return outputWidth
+	}
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		return 0
+	}
+	return width
+}
+
+func tableWidth() int {
+	if width := displayWidth(); width > 0 {
+		return width
+	}
+	return defaultTableWidth
+}
+
+// fitSubject truncates subject so that it fits on one line together with
+// the rest of the line, which takes up reserved columns.
+func fitSubject(subject string, reserved int) string {
+	subject = strings.TrimSpace(subject)
+	width := displayWidth()
+	if width <= 0 {
+		return subject
+	}
+	return truncate(subject, max(width-reserved, 1))
+}
+
+// printMessageTable prints one row per message, splitting the terminal width
+// between the columns and truncating whatever doesn't fit.
+func printMessageTable(messages []Message, labels map[string]Label) {
+	if len(messages) == 0 {
+		fmt.Println("No messages found.")
+		return
+	}
+
+	// Leave room for the date and the padding between the four columns.
+	width := tableWidth() - len(tableDateLayout) - 9
+	senderWidth := width * 3 / 10
+	labelsWidth := width * 2 / 10
+	subjectWidth := width - senderWidth - labelsWidth
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "DATE\tSENDER\tSUBJECT\tLABELS")
+	for _, m := range messages {
+		date := ""
+		if !m.Date.IsZero() {
+			date = formatTime(m.Date, tableDateLayout)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			date,
+			truncate(m.displayName(), senderWidth),
+			truncate(strings.TrimSpace(m.Subject), subjectWidth),
+			formatLabels(m.Labels, labels, labelsWidth))
+	}
+	w.Flush()
+}
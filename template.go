@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are the helpers available to -template besides the builtins.
+var templateFuncs = template.FuncMap{
+	"trim": strings.TrimSpace,
+	// truncate takes the width first so it works in pipelines like
+	// {{.Subject | truncate 30}}.
+	"truncate": func(width int, s string) string {
+		return truncate(s, width)
+	},
+	"date": func(layout string, t time.Time) string {
+		return t.Local().Format(layout)
+	},
+}
+
+func parseOutputTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, usageErrorf("invalid -template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// printTemplate prints every item with tmpl, each followed by a newline.
+func printTemplate[T any](tmpl *template.Template, items []T) error {
+	for _, item := range items {
+		if err := tmpl.Execute(os.Stdout, item); err != nil {
+			return fmt.Errorf("unable to apply -template: %w", err)
+		}
+		fmt.Println()
+	}
+	return nil
+}
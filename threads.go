@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+type Thread struct {
+	Id           string
+	Subject      string
+	Count        int
+	LatestSender string
+}
+
+func listThreads(ctx context.Context, srv *gmail.Service, query MessageQuery) ([]*gmail.Thread, error) {
+	convertedLabelsToSearch, q, err := labelFilter(ctx, srv, query)
+	if err != nil {
+		return nil, err
+	}
+
+	user := mailbox
+	threads := []*gmail.Thread{}
+	pageToken := ""
+	for {
+		call := srv.Users.Threads.List(user).LabelIds(convertedLabelsToSearch...).MaxResults(query.Max - int64(len(threads)))
+		if q != "" {
+			call = call.Q(q)
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		r, err := withRetry(ctx, call.Context(ctx).Do)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve threads: %w", err)
+		}
+		threads = append(threads, r.Threads...)
+		pageToken = r.NextPageToken
+
+		if !query.All || pageToken == "" || int64(len(threads)) >= query.Max {
+			break
+		}
+	}
+	if int64(len(threads)) > query.Max {
+		threads = threads[:query.Max]
+	}
+	return threads, nil
+}
+
+// fetchThread takes the subject from the first message of the thread and
+// the sender from the last, as Gmail returns them oldest first.
+func fetchThread(ctx context.Context, srv *gmail.Service, user string, id string) (Thread, error) {
+	t, err := withRetry(ctx, srv.Users.Threads.Get(user, id).Format("metadata").MetadataHeaders("Subject", "From", "Return-Path").Context(ctx).Do)
+	if err != nil {
+		return Thread{}, err
+	}
+	thread := Thread{Id: id, Count: len(t.Messages)}
+	if len(t.Messages) > 0 {
+		thread.Subject, _ = parseHeaders(t.Messages[0].Payload.Headers)
+		_, thread.LatestSender = parseHeaders(t.Messages[len(t.Messages)-1].Payload.Headers)
+	}
+	return thread, nil
+}
+
+func fetchThreads(ctx context.Context, srv *gmail.Service, user string, listed []*gmail.Thread, workers int) []Thread {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]Thread, len(listed))
+	errs := make([]error, len(listed))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, t := range listed {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = fetchThread(ctx, srv, user, id)
+		}(i, t.Id)
+	}
+	wg.Wait()
+
+	threads := []Thread{}
+	for i, t := range listed {
+		if errs[i] != nil {
+			log.Printf("Unable to retrieve thread %v: %v", t.Id, errs[i])
+			continue
+		}
+		threads = append(threads, results[i])
+	}
+	return threads
+}
+
+func readThreads(ctx context.Context, client *http.Client, query MessageQuery, workers int) ([]Thread, error) {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return nil, err
+	}
+	user := mailbox
+	listed, err := listThreads(ctx, srv, query)
+	if err != nil {
+		return nil, err
+	}
+	return fetchThreads(ctx, srv, user, listed, workers), nil
+}
+
+func printThreads(threads []Thread) {
+	if len(threads) == 0 {
+		fmt.Println("No threads found.")
+		return
+	}
+
+	fmt.Println("")
+	for _, t := range threads {
+		count := fmt.Sprintf(" (%d)", t.Count)
+		fmt.Println(bold("Subject: " + fitSubject(t.Subject, len("Subject: ")+len(count)) + count))
+		fmt.Println("Latest:", t.LatestSender)
+		fmt.Println("")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// tokenWarning is shown whenever a token leaves or enters a profile.
+const tokenWarning = "The token gives access to your mail and calendar without a password, keep it as secret as one."
+
+// exportToken writes the token of the active profile as saved in
+// token.json, to path or stdout. It is encrypted when a passphrase is set,
+// and then needs the same passphrase to import.
+func exportToken(path string) error {
+	tokPath, err := getTokenPath()
+	if err != nil {
+		return err
+	}
+	tok, scopes, err := tokenFromFile(tokPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("profile %s is not logged in", profile)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read token: %w", err)
+	}
+
+	data, err := json.Marshal(storedToken{Token: *tok, Scopes: scopes})
+	if err == nil && tokenPassphrase != "" {
+		data, err = encryptToken(data, tokenPassphrase)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to encode token: %w", err)
+	}
+	data = append(data, '\n')
+
+	log.Print(tokenWarning)
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("unable to write token: %w", err)
+	}
+	infof("Exported the token of profile %s to %s", profile, path)
+	return nil
+}
+
+// importToken saves a token exported with exportToken, or a token.json from
+// another machine, read from path or from stdin for "-", as the token of
+// the active profile.
+func importToken(path string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read token: %w", err)
+	}
+
+	tok, scopes, err := decodeToken(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	// Access tokens expire within the hour, the refresh token is what
+	// keeps butler logged in.
+	if tok.RefreshToken == "" {
+		return errors.New("invalid token: it has no refresh_token")
+	}
+	if tok.TokenType != "" && tok.TokenType != "Bearer" {
+		return fmt.Errorf("invalid token: unexpected token_type %q", tok.TokenType)
+	}
+
+	tokPath, err := getTokenPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(tokPath); err == nil {
+		infof("Replacing the token of profile %s.", profile)
+	}
+	log.Print(tokenWarning)
+	saveToken(tokPath, tok, scopes)
+	fmt.Printf("Imported the token into profile %s.\n", profile)
+	return nil
+}
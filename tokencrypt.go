@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// tokenPassphrase encrypts token.json when set, from -passphrase or
+// BUTLER_PASSPHRASE.
+var tokenPassphrase string
+
+// tokenEncryption names the scheme of encrypted tokens, so that it can
+// change without breaking tokens saved before.
+const tokenEncryption = "scrypt-aes-256-gcm"
+
+// The scrypt parameters recommended for interactive logins in 2017, about
+// 100ms per key.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// encryptedToken is the format of an encrypted token.json. Data is the
+// plaintext token.json sealed with a key derived from the passphrase and
+// Salt.
+type encryptedToken struct {
+	Encryption string `json:"encryption"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+func tokenCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unable to derive the token key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptToken seals a plaintext token.json with passphrase.
+func encryptToken(plaintext []byte, passphrase string) ([]byte, error) {
+	sealed := encryptedToken{Encryption: tokenEncryption, Salt: make([]byte, 16)}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := tokenCipher(passphrase, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Data = aead.Seal(nil, sealed.Nonce, plaintext, []byte(sealed.Encryption))
+	return json.Marshal(sealed)
+}
+
+// decryptToken returns the plaintext of an encrypted token.json, or data
+// unchanged when it isn't encrypted.
+func decryptToken(data []byte, passphrase string) ([]byte, error) {
+	var sealed encryptedToken
+	if err := json.Unmarshal(data, &sealed); err != nil || sealed.Encryption == "" {
+		return data, nil
+	}
+	if sealed.Encryption != tokenEncryption {
+		return nil, fmt.Errorf("unknown token encryption %q", sealed.Encryption)
+	}
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+	aead, err := tokenCipher(passphrase, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid encrypted token")
+	}
+	plaintext, err := aead.Open(nil, sealed.Nonce, sealed.Data, []byte(sealed.Encryption))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestEncryptTokenRoundTrip(t *testing.T) {
+	plaintext := []byte(`{"access_token":"access","refresh_token":"refresh"}`)
+	sealed, err := encryptToken(plaintext, "correct horse")
+	if err != nil {
+		t.Fatalf("encryptToken: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("refresh")) {
+		t.Fatalf("encrypted token contains the plaintext: %s", sealed)
+	}
+	got, err := decryptToken(sealed, "correct horse")
+	if err != nil {
+		t.Fatalf("decryptToken: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("decryptToken = %s, want %s", got, plaintext)
+	}
+}
+
+func TestDecryptTokenWrongPassphrase(t *testing.T) {
+	sealed, err := encryptToken([]byte(`{"refresh_token":"refresh"}`), "correct horse")
+	if err != nil {
+		t.Fatalf("encryptToken: %v", err)
+	}
+	got, err := decryptToken(sealed, "battery staple")
+	if !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("decryptToken with the wrong passphrase = %q, %v, want %v", got, err, ErrWrongPassphrase)
+	}
+	if _, err := decryptToken(sealed, ""); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("decryptToken without a passphrase = %v, want %v", err, ErrPassphraseRequired)
+	}
+}
+
+func TestSavedTokenEncryption(t *testing.T) {
+	defer func(passphrase string) { tokenPassphrase = passphrase }(tokenPassphrase)
+	path := filepath.Join(t.TempDir(), "token.json")
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer"}
+	scopes := []string{"https://www.googleapis.com/auth/gmail.readonly"}
+
+	// Tokens saved before encryption was set up still load, with or
+	// without a passphrase.
+	tokenPassphrase = ""
+	saveToken(path, token, scopes)
+	for _, passphrase := range []string{"", "correct horse"} {
+		tokenPassphrase = passphrase
+		got, granted, err := tokenFromFile(path)
+		if err != nil {
+			t.Fatalf("loading a plaintext token with passphrase %q: %v", passphrase, err)
+		}
+		if got.RefreshToken != token.RefreshToken || len(granted) != 1 || granted[0] != scopes[0] {
+			t.Errorf("loading a plaintext token with passphrase %q = %+v, %v", passphrase, got, granted)
+		}
+	}
+
+	tokenPassphrase = "correct horse"
+	saveToken(path, token, scopes)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(token.RefreshToken)) {
+		t.Fatalf("saved token isn't encrypted: %s", data)
+	}
+	got, _, err := tokenFromFile(path)
+	if err != nil {
+		t.Fatalf("loading the encrypted token: %v", err)
+	}
+	if got.RefreshToken != token.RefreshToken {
+		t.Errorf("loaded refresh token %q, want %q", got.RefreshToken, token.RefreshToken)
+	}
+	tokenPassphrase = "battery staple"
+	if _, _, err := tokenFromFile(path); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("loading with the wrong passphrase = %v, want %v", err, ErrWrongPassphrase)
+	}
+}
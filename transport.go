@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// apiTransport carries every request to Google. Like http.DefaultTransport
+// it goes through the proxy in HTTP_PROXY, HTTPS_PROXY and NO_PROXY, and
+// configureTransport adds -ca-cert and -insecure.
+var apiTransport http.RoundTripper = http.DefaultTransport
+
+// configureTransport trusts the certificates in the PEM file caCert on top
+// of the system ones, as needed behind proxies that intercept TLS. insecure
+// turns certificate verification off altogether.
+func configureTransport(caCert string, insecure bool) error {
+	if caCert == "" && !insecure {
+		return nil
+	}
+	// The clone keeps http.ProxyFromEnvironment.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{}
+	if caCert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			debugf("Unable to load the system certificates: %v", err)
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return fmt.Errorf("unable to read CA certificates: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return usageErrorf("no PEM certificates found in %s", caCert)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if insecure {
+		log.Printf("Warning: -insecure turns off certificate verification, anyone between butler and Google can read and change its requests")
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+	apiTransport = transport
+	return nil
+}
+
+// oauthContext makes the oauth2 package use apiTransport, both for getting
+// tokens and for the clients it returns.
+func oauthContext() context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: &loggingTransport{base: apiTransport}})
+}
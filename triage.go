@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+	"google.golang.org/api/gmail/v1"
+)
+
+// selectMessageIds returns the comma separated ids, or the ids of the
+// messages matching query when none are given. Like listings, the matches
+// leave out messages from -exclude-from senders.
+func selectMessageIds(ctx context.Context, client *http.Client, srv *gmail.Service, ids string, query MessageQuery, workers int) ([]string, error) {
+	messageIds := []string{}
+	if ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			messageIds = append(messageIds, strings.TrimSpace(id))
+		}
+		return messageIds, nil
+	}
+
+	listed, err := listMessages(ctx, srv, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(query.ExcludeFrom) > 0 {
+		listed = dropExcluded(ctx, client, srv, listed, query.ExcludeFrom, workers)
+	}
+	for _, m := range listed {
+		messageIds = append(messageIds, m.Id)
+	}
+	return messageIds, nil
+}
+
+// modifyMessages applies an action to every message, carrying on past
+// failures and reporting them together at the end.
+func modifyMessages(messageIds []string, verb string, done string, apply func(id string) error) error {
+	if len(messageIds) == 0 {
+		fmt.Println("No messages found.")
+		return nil
+	}
+
+	if dryRun {
+		for _, id := range messageIds {
+			apply(id)
+		}
+		fmt.Printf("Dry run: would %s %d messages\n", verb, len(messageIds))
+		return nil
+	}
+
+	failed := 0
+	for _, id := range messageIds {
+		if err := apply(id); err != nil {
+			log.Printf("Unable to %s message %v: %v", verb, id, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: %s\n", done, id)
+	}
+	fmt.Printf("%s %d of %d messages\n", done, len(messageIds)-failed, len(messageIds))
+	if failed > 0 {
+		return fmt.Errorf("unable to %s %d of %d messages", verb, failed, len(messageIds))
+	}
+	return nil
+}
+
+func changeLabels(ctx context.Context, srv *gmail.Service, add []string, remove []string) func(id string) error {
+	return func(id string) error {
+		req := &gmail.ModifyMessageRequest{AddLabelIds: add, RemoveLabelIds: remove}
+		description := fmt.Sprintf("modify message %s, add labels %v, remove labels %v", id, add, remove)
+		return mutate(description, func() error {
+			_, err := withRetry(ctx, srv.Users.Messages.Modify(mailbox, id, req).Context(ctx).Do)
+			return err
+		})
+	}
+}
+
+func markMessagesRead(ctx context.Context, client *http.Client, ids string, query MessageQuery, workers int) error {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	messageIds, err := selectMessageIds(ctx, client, srv, ids, query, workers)
+	if err != nil {
+		return err
+	}
+	return modifyMessages(messageIds, "mark as read", "Marked as read", changeLabels(ctx, srv, nil, []string{"UNREAD"}))
+}
+
+func archiveMessages(ctx context.Context, client *http.Client, ids string, query MessageQuery, workers int) error {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	messageIds, err := selectMessageIds(ctx, client, srv, ids, query, workers)
+	if err != nil {
+		return err
+	}
+	return modifyMessages(messageIds, "archive", "Archived", changeLabels(ctx, srv, nil, []string{"INBOX"}))
+}
+
+func starMessages(ctx context.Context, client *http.Client, ids string, query MessageQuery, workers int, star bool) error {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	messageIds, err := selectMessageIds(ctx, client, srv, ids, query, workers)
+	if err != nil {
+		return err
+	}
+	if star {
+		return modifyMessages(messageIds, "star", "Starred", changeLabels(ctx, srv, []string{"STARRED"}, nil))
+	}
+	return modifyMessages(messageIds, "unstar", "Unstarred", changeLabels(ctx, srv, nil, []string{"STARRED"}))
+}
+
+func trashMessages(ctx context.Context, client *http.Client, ids string, query MessageQuery, workers int, yes bool) error {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+	messageIds, err := selectMessageIds(ctx, client, srv, ids, query, workers)
+	if err != nil {
+		return err
+	}
+
+	if !yes && !dryRun && len(messageIds) > 0 {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return usageErrorf("refusing to trash messages without -yes")
+		}
+		if !confirm(fmt.Sprintf("Move %d messages to the trash?", len(messageIds))) {
+			return nil
+		}
+	}
+
+	return modifyMessages(messageIds, "trash", "Trashed", func(id string) error {
+		return mutate("trash message "+id, func() error {
+			_, err := withRetry(ctx, srv.Users.Messages.Trash(mailbox, id).Context(ctx).Do)
+			return err
+		})
+	})
+}
+
+func confirm(prompt string) bool {
+	fmt.Print(prompt + " [y/N] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"google.golang.org/api/gmail/v1"
+)
+
+// tuiModel is a list of messages next to the body of the selected one.
+type tuiModel struct {
+	ctx        context.Context
+	srv        *gmail.Service
+	timeout    time.Duration
+	messages   []Message
+	cursor     int
+	offset     int
+	bodyOffset int
+	width      int
+	height     int
+	status     string
+}
+
+// tuiActionMsg reports the result of an action on a message.
+type tuiActionMsg struct {
+	id   string
+	verb string
+	err  error
+}
+
+func (m tuiModel) Init() tea.Cmd {
+	return nil
+}
+
+// apply changes the labels of the selected message in the background. With
+// -dry-run the change is only shown in the status bar, as printing it would
+// garble the screen.
+func (m tuiModel) apply(verb string, add []string, remove []string) (tea.Model, tea.Cmd) {
+	if len(m.messages) == 0 {
+		return m, nil
+	}
+	id := m.messages[m.cursor].Id
+	if dryRun {
+		m.status = fmt.Sprintf("Dry run: %s message %s", verb, id)
+		return m, nil
+	}
+	return m, func() tea.Msg {
+		ctx, cancel := m.ctx, context.CancelFunc(func() {})
+		if m.timeout > 0 {
+			ctx, cancel = context.WithTimeout(m.ctx, m.timeout)
+		}
+		defer cancel()
+		return tuiActionMsg{id: id, verb: verb, err: changeLabels(ctx, m.srv, add, remove)(id)}
+	}
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tuiActionMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Unable to %s message: %v", msg.verb, msg.err)
+			break
+		}
+		for i, message := range m.messages {
+			if message.Id != msg.id {
+				continue
+			}
+			if msg.verb == "archive" {
+				m.messages = append(m.messages[:i], m.messages[i+1:]...)
+				if m.cursor >= len(m.messages) && m.cursor > 0 {
+					m.cursor--
+				}
+				m.bodyOffset = 0
+				m.status = "Archived"
+			} else {
+				labels := []string{}
+				for _, l := range message.Labels {
+					if l != "UNREAD" {
+						labels = append(labels, l)
+					}
+				}
+				m.messages[i].Labels = labels
+				m.status = "Marked as read"
+			}
+			break
+		}
+	case tea.KeyMsg:
+		m.status = ""
+		switch msg.String() {
+		case "q", "ctrl+c", "esc":
+			return m, tea.Quit
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+				m.bodyOffset = 0
+			}
+		case "down", "j":
+			if m.cursor < len(m.messages)-1 {
+				m.cursor++
+				m.bodyOffset = 0
+			}
+		case "pgdown", " ":
+			m.bodyOffset += m.listHeight() / 2
+			if last := len(m.bodyLines()) - 1; m.bodyOffset > last {
+				m.bodyOffset = max(last, 0)
+			}
+		case "pgup":
+			m.bodyOffset -= m.listHeight() / 2
+			if m.bodyOffset < 0 {
+				m.bodyOffset = 0
+			}
+		case "r":
+			return m.apply("mark as read", nil, []string{"UNREAD"})
+		case "a":
+			return m.apply("archive", nil, []string{"INBOX"})
+		case "o":
+			if len(m.messages) > 0 {
+				if err := openBrowser(threadURL(m.messages[m.cursor].ThreadId)); err != nil {
+					m.status = fmt.Sprintf("Unable to open browser: %v", err)
+				}
+			}
+		}
+	}
+
+	// Keep the selection on screen.
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	} else if height := m.listHeight(); height > 0 && m.cursor >= m.offset+height {
+		m.offset = m.cursor - height + 1
+	}
+	return m, nil
+}
+
+// listHeight leaves the last line of the screen for the status bar.
+func (m tuiModel) listHeight() int {
+	return m.height - 1
+}
+
+// wrapText breaks s into lines of at most width runes.
+func wrapText(s string, width int) []string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r", ""), "\n") {
+		runes := []rune(strings.ReplaceAll(line, "\t", "    "))
+		for len(runes) > width {
+			lines = append(lines, string(runes[:width]))
+			runes = runes[width:]
+		}
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
+
+func padRight(s string, width int) string {
+	s = truncate(s, width)
+	return s + strings.Repeat(" ", width-len([]rune(s)))
+}
+
+func (m tuiModel) listWidth() int {
+	return m.width * 2 / 5
+}
+
+func (m tuiModel) bodyWidth() int {
+	return m.width - m.listWidth() - 3
+}
+
+// bodyLines is the selected message wrapped to the width of the body pane.
+func (m tuiModel) bodyLines() []string {
+	if len(m.messages) == 0 || m.bodyWidth() < 1 {
+		return nil
+	}
+	selected := m.messages[m.cursor]
+	lines := []string{"From: " + selected.Sender, "Subject: " + strings.TrimSpace(selected.Subject), ""}
+	return append(lines, wrapText(strings.TrimSpace(selected.Body), m.bodyWidth())...)
+}
+
+func (m tuiModel) View() string {
+	if m.width == 0 {
+		return ""
+	}
+	height := m.listHeight()
+	listWidth := m.listWidth()
+	bodyWidth := m.bodyWidth()
+
+	body := m.bodyLines()
+	if m.bodyOffset < len(body) {
+		body = body[m.bodyOffset:]
+	}
+
+	var view strings.Builder
+	for row := 0; row < height; row++ {
+		left := ""
+		i := m.offset + row
+		if i < len(m.messages) {
+			marker := "  "
+			if i == m.cursor {
+				marker = "> "
+			}
+			unread := " "
+			for _, l := range m.messages[i].Labels {
+				if l == "UNREAD" {
+					unread = "*"
+				}
+			}
+			left = marker + unread + " " + strings.TrimSpace(m.messages[i].Subject)
+		}
+		left = padRight(left, listWidth)
+		if i == m.cursor && useColor {
+			left = "\033[7m" + left + "\033[0m"
+		}
+		right := ""
+		if row < len(body) {
+			right = body[row]
+		}
+		view.WriteString(left + " │ " + truncate(right, bodyWidth) + "\n")
+	}
+
+	status := m.status
+	if status == "" {
+		status = fmt.Sprintf("%d messages  j/k move  space/pgup scroll  r read  a archive  o open  q quit", len(m.messages))
+	}
+	view.WriteString(truncate(status, m.width))
+	return view.String()
+}
+
+// runTUI shows the messages matching query in an interactive terminal UI.
+// Every request is limited to timeout rather than the whole session.
+func runTUI(ctx context.Context, client *http.Client, query MessageQuery, fetch FetchOptions, timeout time.Duration) error {
+	srv, err := getGmailService(client)
+	if err != nil {
+		return err
+	}
+
+	readCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		readCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	// The body pane needs full messages.
+	fetch.Snippet = false
+	messages, err := readMail(readCtx, client, query, fetch)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	model := tuiModel{ctx: ctx, srv: srv, timeout: timeout, messages: messages}
+	_, err = tea.NewProgram(model, tea.WithContext(ctx), tea.WithAltScreen()).Run()
+	if errors.Is(err, tea.ErrProgramKilled) && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WatchOptions controls how often -watch polls and what it notifies about.
+type WatchOptions struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	Notify   bool
+	// EventLead is how long before an event starts to notify about it.
+	EventLead time.Duration
+	// Exec runs for every new message after the first poll.
+	Exec ExecOptions
+	// Print is how new messages are shown.
+	Print PrintOptions
+}
+
+// upcomingEvents returns the timed events on the primary calendar starting
+// within lead from now.
+func upcomingEvents(ctx context.Context, client *http.Client, lead time.Duration) ([]Event, error) {
+	now := time.Now()
+	events, err := readCalendar(ctx, client, "primary", now, now.Add(lead), "")
+	if err != nil {
+		return nil, err
+	}
+	upcoming := []Event{}
+	for _, e := range events {
+		// The API also returns events that started already but haven't ended.
+		if !e.AllDay && e.StartTime.After(now) {
+			upcoming = append(upcoming, e)
+		}
+	}
+	return upcoming, nil
+}
+
+// watchMail polls for messages matching query every interval until ctx is
+// cancelled. The first poll prints everything that matches, later ones only
+// messages that weren't seen before. With Notify set, new messages and
+// events about to start are also shown as desktop notifications, and the
+// Exec command runs for new messages.
+func watchMail(ctx context.Context, client *http.Client, query MessageQuery, fetch FetchOptions, watch WatchOptions) error {
+	if watch.Interval <= 0 {
+		return usageErrorf("invalid -interval %s", watch.Interval)
+	}
+
+	seen := map[string]bool{}
+	reminded := map[string]bool{}
+	first := true
+	ticker := time.NewTicker(watch.Interval)
+	defer ticker.Stop()
+	for {
+		pollCtx, cancel := ctx, context.CancelFunc(func() {})
+		if watch.Timeout > 0 {
+			pollCtx, cancel = context.WithTimeout(ctx, watch.Timeout)
+		}
+		messages, err := readMail(pollCtx, client, query, fetch)
+		var events []Event
+		var eventsErr error
+		if err == nil && watch.Notify && watch.EventLead > 0 {
+			events, eventsErr = upcomingEvents(pollCtx, client, watch.EventLead)
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			log.Printf("Unable to check mail: %v", err)
+		} else {
+			fresh := []Message{}
+			for _, m := range messages {
+				if !seen[m.Id] {
+					seen[m.Id] = true
+					fresh = append(fresh, m)
+				}
+			}
+			if first || len(fresh) > 0 {
+				printMessages(fresh, watch.Print)
+			}
+			if !first && watch.Notify {
+				for _, m := range fresh {
+					if err := notify(m.displayName(), strings.TrimSpace(m.Subject)); err != nil {
+						debugf("%v", err)
+					}
+				}
+			}
+			if !first && watch.Exec.Command != "" {
+				runExec(ctx, fresh, watch.Exec)
+			}
+			first = false
+		}
+
+		if eventsErr != nil {
+			log.Printf("Unable to check events: %v", eventsErr)
+		}
+		for _, e := range events {
+			if reminded[e.Id] {
+				continue
+			}
+			reminded[e.Id] = true
+			body := "Starts at " + e.StartTime.Local().Format("15:04")
+			if err := notify(e.Summary, body); err != nil {
+				debugf("%v", err)
+			}
+		}
+		debugf("Checking again in %s", watch.Interval)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}